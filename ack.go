@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ackCallback is the callback of the Acknowledge button on alert cards
+const ackCallback = "prom_ack"
+
+// cardBucket holds the alert cards sent per alert group, so all copies can be updated
+var cardBucket = []byte("cards")
+
+// sentCardRetention is how long cards of an alert group are kept after the last one was sent.
+// Alertmanager repeats notifications of alert groups that keep firing well within it
+const sentCardRetention = 7 * 24 * time.Hour
+
+// sentCard is a copy of an alert card as sent to one recipient
+type sentCard struct {
+	Backend string `json:"backend"`
+	// Name of the message on the backend, e.g. spaces/XXX/messages/YYY
+	Name string `json:"name"`
+	// Thread the card started, e.g. spaces/XXX/threads/ZZZ
+	Thread string    `json:"thread,omitempty"`
+	SentAt time.Time `json:"sentAt,omitempty"`
+}
+
+// space returns the space the card was sent to
+func (card sentCard) space() string {
+	return strings.SplitN(card.Name, "/messages/", 2)[0]
+}
+
+// recordSentCard remembers a card sent about the alert group.
+// It replaces the card sent to the same space before, only the latest one is updated
+func recordSentCard(alertKey, backend, name, thread string) error {
+	if stateDB == nil {
+		return nil
+	}
+	card := sentCard{Backend: backend, Name: name, Thread: thread, SentAt: time.Now()}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cardBucket)
+		var cards []sentCard
+		if data := bucket.Get([]byte(alertKey)); data != nil {
+			if err := json.Unmarshal(data, &cards); err != nil {
+				return err
+			}
+		}
+		var latest []sentCard
+		for _, previous := range cards {
+			if previous.Backend != card.Backend || previous.space() != card.space() {
+				latest = append(latest, previous)
+			}
+		}
+		data, err := json.Marshal(append(latest, card))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(alertKey), data)
+	})
+}
+
+// sentCards returns all cards sent about the alert group
+func sentCards(alertKey string) ([]sentCard, error) {
+	var cards []sentCard
+	if stateDB == nil {
+		return cards, nil
+	}
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(cardBucket).Get([]byte(alertKey)); data != nil {
+			return json.Unmarshal(data, &cards)
+		}
+		return nil
+	})
+	return cards, err
+}
+
+// pruneSentCards drops the cards of alert groups that were not notified about within sentCardRetention,
+// e.g. because botanist missed the resolved webhook
+func pruneSentCards(now time.Time) error {
+	cutoff := now.Add(-sentCardRetention)
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(cardBucket).Cursor()
+		for key, data := cursor.First(); key != nil; {
+			var cards []sentCard
+			if err := json.Unmarshal(data, &cards); err != nil {
+				return err
+			}
+			stale := true
+			for _, card := range cards {
+				stale = stale && card.SentAt.Before(cutoff)
+			}
+			if !stale {
+				key, data = cursor.Next()
+				continue
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			// Delete moves the cursor to the next item
+			key, data = cursor.Seek(key)
+		}
+		return nil
+	})
+}
+
+// forgetSentCards drops the cards of an alert group that resolved
+func forgetSentCards(alertKey string) error {
+	if stateDB == nil {
+		return nil
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cardBucket).Delete([]byte(alertKey))
+	})
+}
+
+// acknowledgeAlert records that the user is working on the alerts of the receiver with the common labels
+// and stops escalating their group, see escalationKey. It returns the cards sent about the group
+func acknowledgeAlert(user User, receiver, key string, commonLabels map[string]string) ([]sentCard, error) {
+	by := user.getUserinfo().FriendlyName
+	if err := recordAlertAction(receiver, commonLabels, "acknowledged", by); err != nil {
+		return nil, err
+	}
+	if err := stopEscalation(key, "acknowledged by "+by); err != nil {
+		log.Errorf("Issues when stopping escalation: %s", err)
+	}
+	auditUser(user, auditAcknowledged, key, map[string]string{"receiver": receiver, "labels": formatAuditParameters(commonLabels)})
+	return sentCards(key)
+}
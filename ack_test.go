@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/template"
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_acknowledgeAlert(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	msg := loadTestWebhook(t)
+	other := loadTestWebhook(t)
+	other.Receiver = "other"
+	other.Alerts[0].Labels["team"] = "other"
+	for _, webhook := range []*notify.WebhookMessage{msg, other} {
+		if err := recordWebhook(webhook); err != nil {
+			t.Fatal(err)
+		}
+		err := stateDB.Update(func(tx *bolt.Tx) error {
+			return putEscalation(tx.Bucket(escalationBucket), &escalation{Key: escalationKey(webhook), Receiver: webhook.Receiver, Labels: webhook.CommonLabels})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	alertKey := escalationKey(msg)
+	for _, name := range []string{"spaces/a/messages/1", "spaces/b/messages/2"} {
+		if err := recordSentCard(alertKey, "hangouts", name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jane := HangoutsUser{&Userinfo{MessagePath: "spaces/a", Username: "users/1", FriendlyName: "Jane"}}
+	cards, err := acknowledgeAlert(jane, msg.Receiver, alertKey, msg.CommonLabels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(cards), 2, "")
+	assertEqual(t, cards[1].Name, "spaces/b/messages/2", "")
+	assertEqual(t, countEscalations(t), 1, "only the escalation of the acknowledged group stops")
+
+	records, err := queryHistory(msg.CommonLabels, msg.Alerts[0].StartsAt.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(records), 2, "")
+	for _, record := range records {
+		if record.Receiver != msg.Receiver {
+			assertEqual(t, len(record.Actions), 0, "alerts of other receivers are not acknowledged")
+			continue
+		}
+		assertEqual(t, len(record.Actions), 1, "")
+		assertEqual(t, record.Actions[0].Action, "acknowledged", "")
+		assertEqual(t, record.Actions[0].By, "Jane", "")
+	}
+
+	entries, err := queryAudit("Jane", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(entries), 1, "")
+	assertEqual(t, entries[0].Action, auditAcknowledged, "")
+
+	// The group resolves with other common labels than it fired with
+	resolved := loadTestWebhook(t)
+	resolved.Status = "resolved"
+	resolved.CommonLabels = template.KV{"alertname": "WakeupTest"}
+	if err := forgetSentCards(escalationKey(resolved)); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = sentCards(alertKey)
+	assertEqual(t, len(cards), 0, "")
+}
+
+func Test_recordSentCard(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	for _, name := range []string{"spaces/a/messages/1", "spaces/b/messages/2", "spaces/a/messages/3"} {
+		if err := recordSentCard("wakeup/alert", "hangouts", name, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cards, err := sentCards("wakeup/alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(cards), 2, "only the latest card per space is kept")
+	assertEqual(t, cards[0].Name, "spaces/b/messages/2", "")
+	assertEqual(t, cards[1].Name, "spaces/a/messages/3", "")
+
+	if err := pruneSentCards(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = sentCards("wakeup/alert")
+	assertEqual(t, len(cards), 2, "recent cards are kept")
+	if err := pruneSentCards(time.Now().Add(sentCardRetention + time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	cards, _ = sentCards("wakeup/alert")
+	assertEqual(t, len(cards), 0, "stale cards are pruned")
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const adminAPIPrefix = "/api/v1/"
+
+// AdminAPIClient may use the admin API. Credentials work like those of webhook senders
+type AdminAPIClient WebhookSender
+
+// adminClientKey is the request context key of the authenticated admin API client's name
+type adminClientKey struct{}
+
+// adminClientName returns the name of the client that made an authenticated request
+func adminClientName(r *http.Request) string {
+	name, _ := r.Context().Value(adminClientKey{}).(string)
+	return name
+}
+
+// alertGroupSummary is an alert group as listed by the admin API
+type alertGroupSummary struct {
+	Name string `json:"name"`
+	// Number of subscribers per backend
+	Subscribers map[string]int `json:"subscribers"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Failed to write API response: %s", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// authenticateAdmin only passes on requests of configured admin API clients.
+// Without any configured clients the API is disabled
+func authenticateAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clients := currentConfig().AdminAPIClients
+		if len(clients) == 0 {
+			writeAPIError(w, http.StatusForbidden, "admin API is disabled, configure adminAPIClients to enable it")
+			return
+		}
+		reqLog := log.WithField("remote_addr", r.RemoteAddr).WithField("path", r.URL.Path)
+		body, err := peekBody(w, r)
+		if err == errRequestTooLarge {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "request body is larger than %d bytes", maxRequestBody)
+			return
+		}
+		if err != nil {
+			reqLog.WithError(err).Error("Failed to read request body")
+			writeAPIError(w, http.StatusBadRequest, "unable to read request body")
+			return
+		}
+		for _, client := range clients {
+			if WebhookSender(client).authenticates(r, body) {
+				reqLog.Infof("%s %s by %s", r.Method, r.URL.Path, client.Name)
+				next(w, r.WithContext(context.WithValue(r.Context(), adminClientKey{}, client.Name)))
+				return
+			}
+		}
+		reqLog.Warnln("Rejecting unauthenticated admin API request")
+		writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+	}
+}
+
+// adminAPIHandler routes the admin API:
+//
+//	GET    /api/v1/groups
+//	GET    /api/v1/groups/<group>/subscribers
+//	POST   /api/v1/groups/<group>/subscribers
+//	DELETE /api/v1/groups/<group>/subscribers/<backend>/<messagePath>
+//	GET    /api/v1/subscriptions?messagePath=...&username=...
+//	DELETE /api/v1/silences/<alertmanager>/<silenceID>
+//	GET    /api/v1/audit?user=...&since=...
+func adminAPIHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Split(strings.TrimPrefix(r.URL.Path, adminAPIPrefix), "/")
+	switch {
+	case len(path) == 1 && path[0] == "groups" && r.Method == http.MethodGet:
+		listAlertGroups(w, r)
+	case len(path) == 3 && path[0] == "groups" && path[2] == "subscribers" && r.Method == http.MethodGet:
+		listGroupSubscribers(w, path[1])
+	case len(path) == 3 && path[0] == "groups" && path[2] == "subscribers" && r.Method == http.MethodPost:
+		addGroupSubscriber(w, r, path[1])
+	case len(path) >= 5 && path[0] == "groups" && path[2] == "subscribers" && r.Method == http.MethodDelete:
+		removeGroupSubscriber(w, r, Subscription{AlertGroup: path[1], Backend: path[3], Userinfo: Userinfo{MessagePath: strings.Join(path[4:], "/")}})
+	case len(path) == 1 && path[0] == "subscriptions" && r.Method == http.MethodGet:
+		listUserSubscriptions(w, r)
+	case len(path) == 3 && path[0] == "silences" && r.Method == http.MethodDelete:
+		expireSilence(w, r, path[1], path[2])
+	case len(path) == 1 && path[0] == "audit" && r.Method == http.MethodGet:
+		exportAudit(w, r)
+	default:
+		writeAPIError(w, http.StatusNotFound, "no such endpoint")
+	}
+}
+
+// knownAlertGroups returns all groups with subscribers or referenced by the config
+func knownAlertGroups(subs []Subscription) []alertGroupSummary {
+	groups := make(map[string]map[string]int)
+	addGroup := func(name string) {
+		if name != "" && groups[name] == nil {
+			groups[name] = make(map[string]int)
+		}
+	}
+	activeConfig := currentConfig()
+	addGroup(activeConfig.Report.AlertGroup)
+	for _, source := range activeConfig.IngestSources {
+		if source.AlertGroup != "" {
+			addGroup(source.AlertGroup)
+		} else {
+			addGroup(source.Name)
+		}
+	}
+	for _, sub := range subs {
+		addGroup(sub.AlertGroup)
+		groups[sub.AlertGroup][sub.Backend]++
+	}
+
+	summaries := make([]alertGroupSummary, 0, len(groups))
+	for name, counts := range groups {
+		summaries = append(summaries, alertGroupSummary{Name: name, Subscribers: counts})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	return summaries
+}
+
+func listAlertGroups(w http.ResponseWriter, r *http.Request) {
+	subs, err := subscriptions.All()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to read subscriptions: %s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, knownAlertGroups(subs))
+}
+
+func listGroupSubscribers(w http.ResponseWriter, alertGroup string) {
+	subs, err := subscriptions.Subscribers(alertGroup)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to read subscriptions: %s", err)
+		return
+	}
+	if subs == nil {
+		subs = []Subscription{}
+	}
+	writeJSON(w, http.StatusOK, subs)
+}
+
+func addGroupSubscriber(w http.ResponseWriter, r *http.Request, alertGroup string) {
+	var sub Subscription
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid subscription: %s", err)
+		return
+	}
+	sub.AlertGroup = alertGroup
+	if sub.Backend == "" {
+		sub.Backend = "hangouts"
+	}
+	if sub.MessagePath == "" {
+		writeAPIError(w, http.StatusBadRequest, "messagePath is required")
+		return
+	}
+	if sub.Username == "" {
+		sub.Username = sub.MessagePath
+	}
+	if sub.FriendlyName == "" {
+		sub.FriendlyName = sub.MessagePath
+	}
+	if _, err := sub.user(); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	if err := subscriptions.Subscribe(sub); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to subscribe: %s", err)
+		return
+	}
+	audit(adminClientName(r), "", auditPlatformAPI, auditSubscribed, alertGroup, map[string]string{"backend": sub.Backend, "messagePath": sub.MessagePath})
+	writeJSON(w, http.StatusCreated, sub)
+}
+
+func removeGroupSubscriber(w http.ResponseWriter, r *http.Request, sub Subscription) {
+	if err := subscriptions.Unsubscribe(sub); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to unsubscribe: %s", err)
+		return
+	}
+	audit(adminClientName(r), "", auditPlatformAPI, auditUnsubscribed, sub.AlertGroup, map[string]string{"backend": sub.Backend, "messagePath": sub.MessagePath})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listUserSubscriptions(w http.ResponseWriter, r *http.Request) {
+	messagePath := r.URL.Query().Get("messagePath")
+	username := r.URL.Query().Get("username")
+	if messagePath == "" && username == "" {
+		writeAPIError(w, http.StatusBadRequest, "messagePath or username is required")
+		return
+	}
+	subs, err := subscriptions.All()
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to read subscriptions: %s", err)
+		return
+	}
+	userSubs := []Subscription{}
+	for _, sub := range subs {
+		if (messagePath == "" || sub.MessagePath == messagePath) && (username == "" || sub.Username == username) {
+			userSubs = append(userSubs, sub)
+		}
+	}
+	writeJSON(w, http.StatusOK, userSubs)
+}
+
+func expireSilence(w http.ResponseWriter, r *http.Request, alertmanager, silenceID string) {
+	amConfig, ok := getAlertmanagerByName(alertmanager)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "unknown Alertmanager %s", alertmanager)
+		return
+	}
+	if err := amConfig.expireSilence(r.Context(), silenceID); err != nil {
+		writeAPIError(w, http.StatusBadGateway, "unable to expire silence: %s", err)
+		return
+	}
+	audit(adminClientName(r), "", auditPlatformAPI, auditSilenceExpired, silenceID, map[string]string{"alertmanager": amConfig.displayName()})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// exportAudit writes the audit log as JSON lines
+func exportAudit(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if sinceText := r.URL.Query().Get("since"); sinceText != "" {
+		var err error
+		since, err = parseSince(sinceText, time.Now())
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+	entries, err := queryAudit(r.URL.Query().Get("user"), since)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "unable to read audit log: %s", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	if err := writeAuditLog(w, entries); err != nil {
+		log.Errorf("Failed to write audit log: %s", err)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_adminAPI(t *testing.T) {
+	oldClients, oldSubscriptions := botanistConfig.AdminAPIClients, subscriptions
+	defer func() { botanistConfig.AdminAPIClients, subscriptions = oldClients, oldSubscriptions }()
+	subscriptions = newFileSubscriptionStore("")
+	handler := authenticateAdmin(adminAPIHandler)
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret-token")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Disabled without clients
+	assertEqual(t, request("GET", "/api/v1/groups", "").Code, http.StatusForbidden, "")
+	botanistConfig.AdminAPIClients = []AdminAPIClient{{Name: "provisioning", BearerToken: "secret-token"}}
+
+	rr := request("POST", "/api/v1/groups/wakeup/subscribers", `{"messagePath": "spaces/jane", "friendlyName": "Jane"}`)
+	assertEqual(t, rr.Code, http.StatusCreated, rr.Body.String())
+	rr = request("POST", "/api/v1/groups/wakeup/subscribers", `{"backend": "pager", "messagePath": "spaces/joe"}`)
+	assertEqual(t, rr.Code, http.StatusBadRequest, rr.Body.String())
+
+	var groups []alertGroupSummary
+	rr = request("GET", "/api/v1/groups", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &groups); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(groups), 1, "")
+	assertEqual(t, groups[0].Subscribers["hangouts"], 1, "")
+
+	var subs []Subscription
+	rr = request("GET", "/api/v1/subscriptions?messagePath=spaces/jane", "")
+	if err := json.Unmarshal(rr.Body.Bytes(), &subs); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(subs), 1, "")
+	assertEqual(t, subs[0].FriendlyName, "Jane", "")
+
+	assertEqual(t, request("DELETE", "/api/v1/groups/wakeup/subscribers/hangouts/spaces/jane", "").Code, http.StatusNoContent, "")
+	rr = request("GET", "/api/v1/groups/wakeup/subscribers", "")
+	assertEqual(t, strings.TrimSpace(rr.Body.String()), "[]", "")
+
+	req := httptest.NewRequest("GET", "/api/v1/groups", nil)
+	unauthorized := httptest.NewRecorder()
+	handler.ServeHTTP(unauthorized, req)
+	assertEqual(t, unauthorized.Code, http.StatusUnauthorized, "")
+}
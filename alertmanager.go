@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AlertmanagerConfig describes how botanist talks to one Alertmanager cluster
+type AlertmanagerConfig struct {
+	// Name used in chat commands to address this Alertmanager
+	Name string `yaml:"name,omitempty"`
+	// ExternalURL as sent by Alertmanager in its webhooks.
+	// Used to find the right config for incoming alerts
+	ExternalURL string `yaml:"externalURL"`
+	// URL botanist uses to reach the Alertmanager API.
+	// Only needed if ExternalURL is not reachable from botanist
+	URL string `yaml:"url,omitempty"`
+	// URLs of the other members of the HA cluster.
+	// Tried in order if URL does not answer
+	Peers []string `yaml:"peers,omitempty"`
+
+	HTTPClientConfig `yaml:",inline"`
+}
+
+// alertmanagerClient speaks to the Alertmanager v2 API of one cluster member
+type alertmanagerClient struct {
+	*apiClient
+}
+
+// amMatcher is a label matcher as used by the Alertmanager v2 API
+type amMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+}
+
+// amSilence is a silence as used by the Alertmanager v2 API
+type amSilence struct {
+	ID        string      `json:"id,omitempty"`
+	Matchers  []amMatcher `json:"matchers"`
+	StartsAt  time.Time   `json:"startsAt"`
+	EndsAt    time.Time   `json:"endsAt"`
+	CreatedBy string      `json:"createdBy"`
+	Comment   string      `json:"comment"`
+	Status    *struct {
+		State string `json:"state"`
+	} `json:"status,omitempty"`
+}
+
+// amAlert is an alert as returned by the Alertmanager v2 API
+type amAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	Status       struct {
+		State       string   `json:"state"`
+		SilencedBy  []string `json:"silencedBy"`
+		InhibitedBy []string `json:"inhibitedBy"`
+	} `json:"status"`
+}
+
+// getAlertmanagerConfig returns the config matching an Alertmanager's ExternalURL.
+// Unknown Alertmanagers are contacted on their ExternalURL without credentials
+func getAlertmanagerConfig(externalURL string) AlertmanagerConfig {
+	for _, amConfig := range currentConfig().Alertmanagers {
+		if strings.TrimSuffix(amConfig.ExternalURL, "/") == strings.TrimSuffix(externalURL, "/") {
+			return amConfig
+		}
+	}
+	return AlertmanagerConfig{ExternalURL: externalURL}
+}
+
+// getAlertmanagerByName returns the configured Alertmanager with the given name
+func getAlertmanagerByName(name string) (AlertmanagerConfig, bool) {
+	for _, amConfig := range currentConfig().Alertmanagers {
+		if amConfig.Name == name {
+			return amConfig, true
+		}
+	}
+	return AlertmanagerConfig{}, false
+}
+
+// addresses returns all API addresses of the cluster in the order they should be tried
+func (amConfig AlertmanagerConfig) addresses() []string {
+	address := amConfig.URL
+	if address == "" {
+		address = amConfig.ExternalURL
+	}
+	return append([]string{address}, amConfig.Peers...)
+}
+
+// clients returns an API client for every reachable address of the cluster
+func (amConfig AlertmanagerConfig) clients() ([]*alertmanagerClient, error) {
+	var clients []*alertmanagerClient
+	for _, address := range amConfig.addresses() {
+		client, err := newAPIClient(address, amConfig.HTTPClientConfig)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, &alertmanagerClient{client})
+	}
+	return clients, nil
+}
+
+// displayName is how we refer to this Alertmanager in messages
+func (amConfig AlertmanagerConfig) displayName() string {
+	if amConfig.Name != "" {
+		return amConfig.Name
+	}
+	return amConfig.ExternalURL
+}
+
+// postSilence creates the silence on the first cluster member that accepts it
+func (amConfig AlertmanagerConfig) postSilence(ctx context.Context, silence amSilence) (string, error) {
+	clients, err := amConfig.clients()
+	if err != nil {
+		return "", err
+	}
+	for _, client := range clients {
+		var silenceID string
+		silenceID, err = client.postSilence(ctx, silence)
+		if err == nil {
+			return silenceID, nil
+		}
+		log.Warnf("Could not create silence via %s: %s", client.baseURL, err)
+	}
+	return "", err
+}
+
+// expireSilence expires the silence via the first cluster member that accepts it
+func (amConfig AlertmanagerConfig) expireSilence(ctx context.Context, silenceID string) error {
+	clients, err := amConfig.clients()
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err = client.deleteSilence(ctx, silenceID); err == nil {
+			return nil
+		}
+		log.Warnf("Could not expire silence via %s: %s", client.baseURL, err)
+	}
+	return err
+}
+
+// getAlerts fetches the alerts from the first cluster member that answers
+func (amConfig AlertmanagerConfig) getAlerts(ctx context.Context, filter []string) ([]amAlert, error) {
+	clients, err := amConfig.clients()
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		var alerts []amAlert
+		alerts, err = client.getAlerts(ctx, filter)
+		if err == nil {
+			return alerts, nil
+		}
+		log.Warnf("Could not fetch alerts via %s: %s", client.baseURL, err)
+	}
+	return nil, err
+}
+
+// alertsSuppressed tells if the Alertmanager no longer notifies about the alerts with the labels,
+// because they were silenced, inhibited or resolved outside of botanist
+func alertsSuppressed(ctx context.Context, externalURL string, labels map[string]string) (bool, error) {
+	if externalURL == "" {
+		return false, nil
+	}
+	var filter []string
+	for name, value := range labels {
+		filter = append(filter, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(filter)
+	alerts, err := getAlertmanagerConfig(externalURL).getAlerts(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	for _, alert := range alerts {
+		if alert.Status.State != "suppressed" {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// getSilences fetches the silences from the first cluster member that answers
+func (amConfig AlertmanagerConfig) getSilences(ctx context.Context, filter []string) ([]amSilence, error) {
+	clients, err := amConfig.clients()
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range clients {
+		var silences []amSilence
+		silences, err = client.getSilences(ctx, filter)
+		if err == nil {
+			return silences, nil
+		}
+		log.Warnf("Could not fetch silences via %s: %s", client.baseURL, err)
+	}
+	return nil, err
+}
+
+// formatAlerts renders a list of alerts as chat text
+func formatAlerts(alerts []amAlert) string {
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].StartsAt.Before(alerts[j].StartsAt)
+	})
+	var text strings.Builder
+	for _, alert := range alerts {
+		fmt.Fprintf(&text, "[%s] %s %s: %s (since %s)\n",
+			alert.Status.State,
+			alert.Labels["alertname"],
+			alert.Labels["instance"],
+			alert.Annotations["summary"],
+			alert.StartsAt.Format(time.RFC822))
+	}
+	return text.String()
+}
+
+// postSilence creates a silence and returns its ID
+func (c *alertmanagerClient) postSilence(ctx context.Context, silence amSilence) (string, error) {
+	var result struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v2/silences", nil, silence, &result); err != nil {
+		return "", err
+	}
+	return result.SilenceID, nil
+}
+
+func (c *alertmanagerClient) deleteSilence(ctx context.Context, silenceID string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v2/silence/"+url.PathEscape(silenceID), nil, nil, nil)
+}
+
+func (c *alertmanagerClient) getSilences(ctx context.Context, filter []string) ([]amSilence, error) {
+	var silences []amSilence
+	err := c.do(ctx, http.MethodGet, "/api/v2/silences", url.Values{"filter": filter}, nil, &silences)
+	return silences, err
+}
+
+func (c *alertmanagerClient) getAlerts(ctx context.Context, filter []string) ([]amAlert, error) {
+	var alerts []amAlert
+	err := c.do(ctx, http.MethodGet, "/api/v2/alerts", url.Values{"filter": filter}, nil, &alerts)
+	return alerts, err
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	chat "google.golang.org/api/chat/v1"
+)
+
+func Test_silenceWithLabels(t *testing.T) {
+	var posted amSilence
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(t, r.Method+" "+r.URL.Path, "POST /api/v2/silences", "")
+		if err := json.NewDecoder(r.Body).Decode(&posted); err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(`{"silenceID": "abc-123"}`))
+	}))
+	defer server.Close()
+
+	silenceID, err := silenceWithLabels(template.KV{"alertname": "Down"}, "Jane", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, silenceID, "abc-123", "")
+	assertEqual(t, len(posted.Matchers), 1, "")
+	assertEqual(t, posted.Matchers[0], amMatcher{Name: "alertname", Value: "Down"}, "")
+	assertEqual(t, posted.CreatedBy, "Jane", "")
+	assertEqual(t, posted.EndsAt.Sub(posted.StartsAt).Round(time.Second), time.Hour, "silences last an hour")
+}
+
+func Test_handleClickSilenceError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "silence rejected", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	event := &chat.DeprecatedEvent{
+		EventTime: time.Now().Format(time.RFC3339Nano),
+		User:      &chat.User{Name: "users/1", DisplayName: "Jane"},
+		Action: &chat.FormAction{
+			ActionMethodName: "prom_silence_1h",
+			Parameters: []*chat.ActionParameter{
+				{Key: "labels", Value: `{"alertname": "Down"}`},
+				{Key: "alertMgrAddress", Value: server.URL},
+			},
+		},
+	}
+	response := handleClick(event, HangoutsUser{&Userinfo{MessagePath: "spaces/a", Username: "users/1", FriendlyName: "Jane"}})
+	assertEqual(t, strings.HasPrefix(response.Text, "There was an error silencing this alert"), true, response.Text)
+	assertEqual(t, strings.Contains(response.Text, "silence rejected"), true, response.Text)
+}
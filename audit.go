@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbstjn/allot"
+	bolt "go.etcd.io/bbolt"
+)
+
+// auditBucket holds the audit trail. Entries are only ever appended
+var auditBucket = []byte("audit")
+
+// Audited actions
+const (
+	auditSilenceCreated  = "silence.created"
+	auditSilenceExpired  = "silence.expired"
+	auditSubscribed      = "subscription.added"
+	auditUnsubscribed    = "subscription.removed"
+	auditAcknowledged    = "alert.acknowledged"
+	auditOnCallOverride  = "oncall.override"
+	auditScheduleChanged = "schedule.changed"
+	auditScheduleJoined  = "schedule.joined"
+	auditScheduleLeft    = "schedule.left"
+)
+
+// Platforms actions are taken on besides the messaging backends
+const (
+	auditPlatformAPI = "api"
+	auditPlatformCLI = "cli"
+)
+
+// maxAuditMessage is how many audit entries the audit command shows
+const maxAuditMessage = 50
+
+// auditEntry is something a user did through botanist
+type auditEntry struct {
+	ID   uint64    `json:"id"`
+	Time time.Time `json:"time"`
+	// Email or user name of the chat user, name of the API client or system user
+	Actor      string            `json:"actor"`
+	ActorName  string            `json:"actorName,omitempty"`
+	Platform   string            `json:"platform"`
+	Action     string            `json:"action"`
+	Target     string            `json:"target"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// by tells if the entry was made by the user, matching actor or name
+func (entry auditEntry) by(user string) bool {
+	return strings.EqualFold(entry.Actor, user) || strings.EqualFold(entry.ActorName, user)
+}
+
+// recordAudit appends the entry to the audit trail
+func recordAudit(entry auditEntry) error {
+	if stateDB == nil {
+		return nil
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(auditBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		entry.ID = seq
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(sequenceKey(seq), data)
+	})
+}
+
+// audit records an action. Failing to record it does not undo the action, so it is only logged
+func audit(actor, actorName, platform, action, target string, parameters map[string]string) {
+	log.WithField("actor", actor).WithField("platform", platform).WithField("target", target).Infof("Audit: %s", action)
+	entry := auditEntry{Actor: actor, ActorName: actorName, Platform: platform, Action: action, Target: target, Parameters: parameters}
+	if err := recordAudit(entry); err != nil {
+		log.Errorf("Failed to record %s of %s by %s in audit log: %s", action, target, actor, err)
+	}
+}
+
+// auditUser records an action taken by a chat user
+func auditUser(user User, action, target string, parameters map[string]string) {
+	info := user.getUserinfo()
+	actor := info.Email
+	if actor == "" {
+		actor = info.Username
+	}
+	audit(actor, info.FriendlyName, user.getBackend(), action, target, parameters)
+}
+
+// queryAudit returns the entries since the given time, oldest first.
+// Only entries of the user are returned unless user is empty
+func queryAudit(user string, since time.Time) ([]auditEntry, error) {
+	var entries []auditEntry
+	if stateDB == nil {
+		return entries, nil
+	}
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(auditBucket).ForEach(func(_, data []byte) error {
+			var entry auditEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			if entry.Time.Before(since) || (user != "" && !entry.by(user)) {
+				return nil
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	return entries, err
+}
+
+// writeAuditLog exports entries as JSON lines
+func writeAuditLog(w io.Writer, entries []auditEntry) error {
+	encoder := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatAuditParameters(parameters map[string]string) string {
+	var pairs []string
+	for key, value := range parameters {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func formatAudit(user string, entries []auditEntry, since time.Time) string {
+	if len(entries) == 0 && user != "" {
+		return fmt.Sprintf("%s did nothing through botanist since %s", user, since.Format(time.RFC822))
+	}
+	if len(entries) == 0 {
+		return fmt.Sprintf("Nothing was done through botanist since %s", since.Format(time.RFC822))
+	}
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d action(s) since %s", len(entries), since.Format(time.RFC822))
+	if len(entries) > maxAuditMessage {
+		fmt.Fprintf(&text, ", showing the last %d", maxAuditMessage)
+		entries = entries[len(entries)-maxAuditMessage:]
+	}
+	text.WriteString(":\n")
+	for _, entry := range entries {
+		fmt.Fprintf(&text, "- %s %s (%s) %s %s", entry.Time.Format(time.RFC822), entry.Actor, entry.Platform, entry.Action, entry.Target)
+		if len(entry.Parameters) > 0 {
+			fmt.Fprintf(&text, " [%s]", formatAuditParameters(entry.Parameters))
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+// handleAudit answers "audit [user] [since]". A single argument is a time span if it parses as one
+func handleAudit(match allot.MatchInterface, User User) (*genericMessage, error) {
+	now := time.Now()
+	since := now.Add(-defaultHistoryPeriod)
+	user, _ := match.String("user")
+	sinceText, err := match.String("since")
+	if err != nil {
+		if query, err := match.String("query"); err == nil {
+			if _, err := parseSince(query, now); err == nil {
+				sinceText = query
+			} else {
+				user = query
+			}
+		}
+	}
+	if sinceText != "" {
+		since, err = parseSince(sinceText, now)
+		if err != nil {
+			return &genericMessage{ContentText: err.Error()}, nil
+		}
+	}
+
+	entries, err := queryAudit(user, since)
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues reading the audit log"}, err
+	}
+	return &genericMessage{ContentText: formatAudit(user, entries, since)}, nil
+}
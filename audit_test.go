@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_auditLog(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldSubscriptions := subscriptions
+	defer func() { subscriptions = oldSubscriptions }()
+	subscriptions = newFileSubscriptionStore("")
+
+	jane := HangoutsUser{&Userinfo{MessagePath: "spaces/jane", Username: "users/1", FriendlyName: "Jane", Email: "jane@example.com"}}
+	joe := HangoutsUser{&Userinfo{MessagePath: "spaces/joe", Username: "users/2", FriendlyName: "Joe"}}
+	for _, msg := range []*genericMessage{
+		{ContentText: "annoy me about wakeup alerts", Sender: jane},
+		{ContentText: "don't bug me about wakeup alerts", Sender: jane},
+		{ContentText: "annoy me about wakeup alerts", Sender: joe},
+	} {
+		if _, err := handleRequest(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	audit("provisioning", "", auditPlatformAPI, auditSilenceExpired, "1234", map[string]string{"alertmanager": "am"})
+
+	entries, err := queryAudit("", time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(entries), 4, "")
+	assertEqual(t, entries[0].Actor, "jane@example.com", "")
+	assertEqual(t, entries[0].Platform, "hangouts", "")
+	assertEqual(t, entries[0].Action, auditSubscribed, "")
+	assertEqual(t, entries[0].Target, "wakeup", "")
+	assertEqual(t, entries[1].Action, auditUnsubscribed, "")
+	assertEqual(t, entries[2].Actor, "users/2", "")
+
+	entries, _ = queryAudit("Joe", time.Now().Add(-time.Hour))
+	assertEqual(t, len(entries), 1, "filter by friendly name")
+	entries, _ = queryAudit("", time.Now().Add(time.Hour))
+	assertEqual(t, len(entries), 0, "filter by time")
+
+	response, _ := handleRequest(&genericMessage{ContentText: "audit jane@example.com 1h", Sender: jane})
+	assertEqual(t, strings.Count(response.ContentText, "\n- "), 2, response.ContentText)
+	response, _ = handleRequest(&genericMessage{ContentText: "audit 2d", Sender: jane})
+	assertEqual(t, strings.Count(response.ContentText, "\n- "), 4, response.ContentText)
+	response, _ = handleRequest(&genericMessage{ContentText: "audit users/9", Sender: jane})
+	assertEqual(t, strings.HasPrefix(response.ContentText, "users/9 did nothing"), true, response.ContentText)
+
+	rr := httptest.NewRecorder()
+	exportAudit(rr, httptest.NewRequest("GET", "/api/v1/audit?user=provisioning&since=1d", nil))
+	assertEqual(t, rr.Header().Get("Content-Type"), "application/x-ndjson", "")
+	scanner := bufio.NewScanner(rr.Body)
+	var lines int
+	for scanner.Scan() {
+		var entry auditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, entry.Parameters["alertmanager"], "am", "")
+		lines++
+	}
+	assertEqual(t, lines, 1, "")
+}
@@ -1,15 +1,51 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"io/ioutil"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
 )
 
 type config struct {
-	Hangouts HangoutsConfig
+	Hangouts      HangoutsConfig
+	Alertmanagers []AlertmanagerConfig `yaml:"alertmanagers,omitempty"`
+	Prometheus    []PrometheusConfig   `yaml:"prometheus,omitempty"`
+	// Path to the database botanist keeps its state in
+	Database string `yaml:"database,omitempty"`
+	// How long alerts are kept in the history after their last update, defaults to 90 days
+	HistoryRetention time.Duration `yaml:"historyRetention,omitempty"`
+	Report           ReportConfig  `yaml:"report,omitempty"`
+	// Clients allowed to send alerts to botanist
+	WebhookSenders []WebhookSender `yaml:"webhookSenders,omitempty"`
+	// Accept alerts from anyone while no webhookSenders are configured. Only meant for testing
+	AllowUnauthenticated bool `yaml:"allowUnauthenticated,omitempty"`
+	// Clients allowed to use the admin API
+	AdminAPIClients []AdminAPIClient `yaml:"adminAPIClients,omitempty"`
+	Dashboard       DashboardConfig  `yaml:"dashboard,omitempty"`
+	// Escalation of unacknowledged alerts per receiver
+	EscalationPolicies []EscalationPolicy `yaml:"escalationPolicies,omitempty"`
+	// Reminders about alerts that keep firing unacknowledged, per receiver
+	Reminders []ReminderConfig `yaml:"reminders,omitempty"`
+	// On-call rotations, more can be created via chat
+	Schedules []OnCallSchedule `yaml:"schedules,omitempty"`
+	// Alerts delivered during quiet hours and do-not-disturb
+	QuietHours QuietHoursConfig `yaml:"quietHours,omitempty"`
+	// Roles of chat users
+	Permissions PermissionsConfig `yaml:"permissions,omitempty"`
+	HTTP        HTTPServerConfig  `yaml:"http,omitempty"`
+	// Sources of generic JSON alerts posted to /ingest/<name>
+	IngestSources []IngestSource `yaml:"ingestSources,omitempty"`
+	Outbox        OutboxConfig   `yaml:"outbox,omitempty"`
+	// Where subscriptions to alert groups are kept
+	Subscriptions SubscriptionStoreConfig `yaml:"subscriptions,omitempty"`
+	// How long to wait for in-flight work when shutting down, defaults to 30s
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout,omitempty"`
 }
 
 var botanistConfig = &config{}
@@ -22,36 +58,143 @@ var (
 func main() {
 	verbose := flag.Bool("verbose", false, "Increase logging verbosity")
 	configFileLocation = flag.String("configFile", "botanist.conf", "Location of config file in YAML format")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
-	configFile, err := ioutil.ReadFile(*configFileLocation)
+	var err error
+	botanistConfig, err = loadConfig(*configFileLocation)
 	if err != nil {
-		log.Fatalf("Error when trying to read config file at %s", *configFileLocation)
+		log.Fatalf("Error when loading configuration: %s", err)
 	}
-	err = yaml.Unmarshal(configFile, botanistConfig)
-	if err != nil {
-		log.Fatalf("Error when parsing config file: %s", err)
+	if *verbose {
+		log.SetLevel(logrus.DebugLevel)
+	}
+
+	os.Exit(runCommand(flag.Args()))
+}
+
+// runEvery calls fn right away and then every interval until ctx is cancelled
+func runEvery(ctx context.Context, interval time.Duration, fn func(now time.Time)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
+}
+
+// serve runs botanist until it receives SIGTERM or SIGINT or a backend fails
+// and returns the exit status
+func serve() int {
 	log.Infoln("Botanist Starting.")
 	log.Infof("Configuration: Credentials File: %s, Project: %s, Subscription: %s.", botanistConfig.Hangouts.CredentialsFile, botanistConfig.Hangouts.Project, botanistConfig.Hangouts.PsSubscription)
 
-	if *verbose {
-		log.SetLevel(logrus.DebugLevel)
+	if err := openStateDB(botanistConfig.Database); err != nil {
+		log.Fatalf("Error when opening database at %s: %s", botanistConfig.Database, err)
+	}
+	var err error
+	subscriptions, err = newSubscriptionStore(botanistConfig.Subscriptions)
+	if err != nil {
+		log.Fatalf("Error when opening subscription store: %s", err)
+	}
+	if err := migrateConfigSubscriptions(subscriptions, botanistConfig.Hangouts.PromAlertSubscribers); err != nil {
+		log.Fatalf("Error when migrating subscriptions from the config file: %s", err)
+	}
+
+	if len(botanistConfig.Permissions.Roles) == 0 {
+		log.Warnln("No permissions configured - every chat user is an admin")
 	}
 
-	go startPrometheusListener()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	receiveCtx, stopReceiving := context.WithCancel(context.Background())
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+
+	startOutbox(outboxCtx)
+	go watchConfig(receiveCtx, *configFileLocation)
+	go startReportScheduler(receiveCtx)
+	go startEscalationScheduler(receiveCtx)
+	go startHistoryPruning(receiveCtx)
+	go startHandoffReports(receiveCtx)
+	go startDigestScheduler(receiveCtx)
+	go startReminderScheduler(receiveCtx)
+	serverErrors := startHTTPServer()
 
 	// Actively load hangouts
 	// We should make this dependent on what's in the config file in the future
 	// and load only the messaging plattforms that are configured
-	initHangouts()
-	log.Infoln("Botanist Exiting.")
-}
+	hangoutsDone := make(chan error, 1)
+	go func() {
+		hangoutsDone <- runHangouts(receiveCtx)
+	}()
 
-func persistConfigChanges() error {
-	data, err := yaml.Marshal(botanistConfig)
-	if err != nil {
-		return err
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		log.Infof("Received %s, shutting down", sig)
+	case err := <-serverErrors:
+		log.Errorf("HTTP server failed, shutting down: %s", err)
+		exitCode = 1
+	case err := <-hangoutsDone:
+		if err == nil {
+			err = fmt.Errorf("stopped receiving messages")
+		}
+		log.Errorf("Hangouts backend failed, shutting down: %s", err)
+		exitCode = 1
+		hangoutsDone = nil
+	}
+
+	go func() {
+		sig := <-signals
+		log.Warnf("Received %s again, exiting immediately", sig)
+		os.Exit(1)
+	}()
+
+	timeout := currentConfig().ShutdownTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
 	}
-	return ioutil.WriteFile(*configFileLocation, data, 0600)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Stop accepting webhooks and let the running ones finish
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Errorf("Failed to stop HTTP server: %s", err)
+		exitCode = 1
+	}
+	// Stop receiving chat events and finish handling the received ones
+	stopReceiving()
+	if hangoutsDone != nil {
+		select {
+		case err := <-hangoutsDone:
+			if err != nil {
+				log.Errorf("Hangouts backend stopped with error: %s", err)
+			}
+		case <-shutdownCtx.Done():
+			log.Errorln("Timed out waiting for chat events to be handled")
+			exitCode = 1
+		}
+	}
+	if err := messageOutbox.drain(shutdownCtx, stopOutbox); err != nil {
+		log.Errorf("Failed to drain outbox: %s", err)
+		exitCode = 1
+	}
+
+	if err := subscriptions.Close(); err != nil {
+		log.Errorf("Failed to close subscription store: %s", err)
+		exitCode = 1
+	}
+	if err := stateDB.Close(); err != nil {
+		log.Errorf("Failed to close database: %s", err)
+		exitCode = 1
+	}
+	log.Infoln("Botanist Exiting.")
+	return exitCode
 }
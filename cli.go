@@ -0,0 +1,434 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+)
+
+const usage = `Usage: botanist [flags] <command> [arguments]
+
+Commands:
+  serve                           Run the bot (default)
+  check-config                    Validate the config file and the files it refers to
+  send-test-alert --receiver X    Post a test alert to a running botanist
+  subscribers list [alertGroup]   List subscriptions
+  subscribers add [--name N] [--user U] <alertGroup> <messagePath>
+  subscribers remove <alertGroup> <messagePath>
+
+Flags:
+`
+
+// runCommand executes the subcommand in args and returns its exit status
+func runCommand(args []string) int {
+	command := "serve"
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+	var err error
+	switch command {
+	case "serve":
+		return serve()
+	case "check-config":
+		err = checkConfig(botanistConfig)
+		if err == nil {
+			fmt.Printf("Config file %s is valid\n", *configFileLocation)
+		}
+	case "send-test-alert":
+		err = sendTestAlert(args)
+	case "subscribers":
+		err = manageSubscribers(args)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		flag.Usage()
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 1
+	}
+	return 0
+}
+
+// checkConfig validates the files referenced by an already loaded config
+func checkConfig(c *config) error {
+	if c.Hangouts.CredentialsFile == "" {
+		return fmt.Errorf("hangouts.credentialsFile is not set")
+	}
+	credentials, err := ioutil.ReadFile(c.Hangouts.CredentialsFile)
+	if err != nil {
+		return fmt.Errorf("unable to read credentials file: %s", err)
+	}
+	if !json.Valid(credentials) {
+		return fmt.Errorf("credentials file %s is not valid JSON", c.Hangouts.CredentialsFile)
+	}
+	if _, err := newHTTPServer(c.HTTP); err != nil {
+		return fmt.Errorf("http: %s", err)
+	}
+	return nil
+}
+
+// localBaseURL returns the address of a botanist running with this config
+func localBaseURL(serverConfig HTTPServerConfig) string {
+	listenAddress := serverConfig.ListenAddress
+	if listenAddress == "" {
+		listenAddress = defaultListenAddress
+	}
+	host, port, err := net.SplitHostPort(listenAddress)
+	if err != nil || host == "" {
+		host = "localhost"
+	}
+	scheme := "http"
+	if serverConfig.CertFile != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, port))
+}
+
+// localWebhookURL returns the address of the /alert endpoint of a botanist running with this config
+func localWebhookURL(serverConfig HTTPServerConfig) string {
+	return localBaseURL(serverConfig) + "/alert"
+}
+
+// testAlert returns a webhook like Alertmanager would send it for a single alert
+func testAlert(receiver string, resolved bool, now time.Time) *notify.WebhookMessage {
+	hostname, _ := os.Hostname()
+	labels := template.KV{"alertname": "BotanistTestAlert", "instance": hostname, "severity": "none"}
+	alert := template.Alert{
+		Status:      string(model.AlertFiring),
+		Labels:      labels,
+		Annotations: template.KV{"summary": "Test alert sent by botanist send-test-alert"},
+		StartsAt:    now,
+	}
+	if resolved {
+		alert.Status = string(model.AlertResolved)
+		alert.EndsAt = now
+	}
+	return &notify.WebhookMessage{
+		Data: &template.Data{
+			Receiver:          receiver,
+			Status:            alert.Status,
+			Alerts:            template.Alerts{alert},
+			GroupLabels:       template.KV{"alertname": labels["alertname"]},
+			CommonLabels:      labels,
+			CommonAnnotations: alert.Annotations,
+		},
+		Version:  "4",
+		GroupKey: fmt.Sprintf("{}:{alertname=%q}", labels["alertname"]),
+	}
+}
+
+// senderNamed returns the sender whose credentials sign a request, the first one if name is empty.
+// It returns nil if no senders are configured
+func senderNamed(senders []WebhookSender, name string) (*WebhookSender, error) {
+	if name == "" {
+		if len(senders) == 0 {
+			return nil, nil
+		}
+		return &senders[0], nil
+	}
+	for i := range senders {
+		if senders[i].Name == name {
+			return &senders[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown sender %q", name)
+}
+
+// cliHTTPClient returns the client commands talk to a running botanist with
+func cliHTTPClient(insecure bool) *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}},
+	}
+}
+
+func sendTestAlert(args []string) error {
+	flags := flag.NewFlagSet("send-test-alert", flag.ExitOnError)
+	receiver := flags.String("receiver", "", "Alert group to send the alert to")
+	webhookURL := flags.String("url", localWebhookURL(botanistConfig.HTTP), "URL of botanist's /alert endpoint")
+	senderName := flags.String("sender", "", "Webhook sender whose credentials are used, defaults to the first one configured")
+	resolved := flags.Bool("resolved", false, "Send the alert as resolved")
+	insecure := flags.Bool("insecure", false, "Do not verify the server certificate")
+	flags.Parse(args)
+	if *receiver == "" {
+		return fmt.Errorf("--receiver is required")
+	}
+
+	body, err := json.Marshal(testAlert(*receiver, *resolved, time.Now()))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, *webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sender, err := senderNamed(botanistConfig.WebhookSenders, *senderName)
+	if err != nil {
+		return err
+	}
+	if sender != nil {
+		if err := sender.sign(req, body); err != nil {
+			return fmt.Errorf("unable to read credentials of sender %s: %s", sender.Name, err)
+		}
+	}
+
+	resp, err := cliHTTPClient(*insecure).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("botanist answered %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	fmt.Printf("Sent test alert to alert group %s\n", *receiver)
+	return nil
+}
+
+// openSubscriptionStore opens the configured store outside of a running botanist
+func openSubscriptionStore() (SubscriptionStore, error) {
+	storeConfig := botanistConfig.Subscriptions
+	if storeConfig.Type == "" || storeConfig.Type == "bolt" {
+		if err := openStateDB(botanistConfig.Database); err != nil {
+			return nil, fmt.Errorf("unable to open database %s (is botanist still running?): %s", botanistConfig.Database, err)
+		}
+	}
+	return newSubscriptionStore(storeConfig)
+}
+
+// systemUser is the name of the user running the command, the actor of its audit log entries
+func systemUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// listening tells if something accepts connections at the host of baseURL
+func listening(baseURL string) bool {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return false
+	}
+	host := parsed.Host
+	if parsed.Port() == "" {
+		port := "80"
+		if parsed.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(parsed.Hostname(), port)
+	}
+	conn, err := net.DialTimeout("tcp", host, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+// adminAPIStore is a SubscriptionStore kept by a running botanist, accessed through its admin API
+type adminAPIStore struct {
+	baseURL string
+	client  *http.Client
+	sender  *WebhookSender
+}
+
+// do sends in as JSON and decodes the answer into out if it is not nil
+func (api *adminAPIStore) do(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(api.baseURL, "/")+adminAPIPrefix+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if err := api.sender.sign(req, body); err != nil {
+		return fmt.Errorf("unable to read credentials of admin API client %s: %s", api.sender.Name, err)
+	}
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiError struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiError) != nil || apiError.Error == "" {
+			apiError.Error = resp.Status
+		}
+		return fmt.Errorf("botanist answered %s: %s", resp.Status, apiError.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (api *adminAPIStore) Subscribe(sub Subscription) error {
+	return api.do(http.MethodPost, "groups/"+url.PathEscape(sub.AlertGroup)+"/subscribers", sub, nil)
+}
+
+func (api *adminAPIStore) Unsubscribe(sub Subscription) error {
+	return api.do(http.MethodDelete, "groups/"+url.PathEscape(sub.AlertGroup)+"/subscribers/"+url.PathEscape(sub.Backend)+"/"+sub.MessagePath, nil, nil)
+}
+
+func (api *adminAPIStore) Subscribers(alertGroup string) ([]Subscription, error) {
+	var subs []Subscription
+	err := api.do(http.MethodGet, "groups/"+url.PathEscape(alertGroup)+"/subscribers", nil, &subs)
+	return subs, err
+}
+
+func (api *adminAPIStore) All() ([]Subscription, error) {
+	var groups []alertGroupSummary
+	if err := api.do(http.MethodGet, "groups", nil, &groups); err != nil {
+		return nil, err
+	}
+	var all []Subscription
+	for _, group := range groups {
+		subs, err := api.Subscribers(group.Name)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, subs...)
+	}
+	return all, nil
+}
+
+func (api *adminAPIStore) Close() error {
+	return nil
+}
+
+// runningStore returns the admin API of the botanist running at baseURL
+func runningStore(baseURL, clientName string, insecure bool) (SubscriptionStore, error) {
+	clients := make([]WebhookSender, len(botanistConfig.AdminAPIClients))
+	for i, client := range botanistConfig.AdminAPIClients {
+		clients[i] = WebhookSender(client)
+	}
+	sender, err := senderNamed(clients, clientName)
+	if err != nil {
+		return nil, err
+	}
+	if sender == nil {
+		return nil, fmt.Errorf("botanist is running at %s: configure adminAPIClients to manage subscribers while it runs, or stop it", baseURL)
+	}
+	return &adminAPIStore{baseURL: baseURL, client: cliHTTPClient(insecure), sender: sender}, nil
+}
+
+// manageSubscribers edits the subscriptions through the admin API while botanist is running
+// and directly in the store while it is stopped
+func manageSubscribers(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected list, add or remove")
+	}
+	flags := flag.NewFlagSet("subscribers "+args[0], flag.ExitOnError)
+	backend := flags.String("backend", "hangouts", "Messaging backend of the subscriber")
+	name := flags.String("name", "", "Friendly name of the subscriber, defaults to the message path")
+	username := flags.String("user", "", "User name of the subscriber, defaults to the message path")
+	baseURL := flags.String("url", localBaseURL(botanistConfig.HTTP), "URL of a running botanist, whose admin API is used")
+	clientName := flags.String("client", "", "Admin API client whose credentials are used, defaults to the first one configured")
+	insecure := flags.Bool("insecure", false, "Do not verify the server certificate")
+	flags.Parse(args[1:])
+
+	var sub Subscription
+	switch args[0] {
+	case "list":
+		if flags.NArg() > 1 {
+			return fmt.Errorf("usage: subscribers list [alertGroup]")
+		}
+	case "add", "remove":
+		if flags.NArg() != 2 {
+			return fmt.Errorf("usage: subscribers %s [flags] <alertGroup> <messagePath>", args[0])
+		}
+		sub = Subscription{AlertGroup: flags.Arg(0), Backend: *backend, Userinfo: Userinfo{MessagePath: flags.Arg(1), Username: *username, FriendlyName: *name}}
+		if sub.Username == "" {
+			sub.Username = sub.MessagePath
+		}
+		if sub.FriendlyName == "" {
+			sub.FriendlyName = sub.MessagePath
+		}
+		if _, err := sub.user(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown subscribers command %q, expected list, add or remove", args[0])
+	}
+
+	// The running botanist would overwrite or lock the store, so changes go through it
+	var store SubscriptionStore
+	var err error
+	remote := listening(*baseURL)
+	if remote {
+		store, err = runningStore(*baseURL, *clientName, *insecure)
+	} else {
+		store, err = openSubscriptionStore()
+	}
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if stateDB != nil {
+		defer stateDB.Close()
+	}
+
+	switch args[0] {
+	case "add":
+		if err := store.Subscribe(sub); err != nil {
+			return err
+		}
+		// The admin API records its own audit log entries
+		if !remote {
+			audit(systemUser(), "", auditPlatformCLI, auditSubscribed, sub.AlertGroup, map[string]string{"backend": sub.Backend, "messagePath": sub.MessagePath})
+		}
+		fmt.Printf("Subscribed %s to alert group %s\n", sub.FriendlyName, sub.AlertGroup)
+	case "remove":
+		if err := store.Unsubscribe(sub); err != nil {
+			return err
+		}
+		if !remote {
+			audit(systemUser(), "", auditPlatformCLI, auditUnsubscribed, sub.AlertGroup, map[string]string{"backend": sub.Backend, "messagePath": sub.MessagePath})
+		}
+		fmt.Printf("Unsubscribed %s from alert group %s\n", sub.MessagePath, sub.AlertGroup)
+	case "list":
+		var subs []Subscription
+		if flags.NArg() == 1 {
+			subs, err = store.Subscribers(flags.Arg(0))
+		} else {
+			subs, err = store.All()
+		}
+		if err != nil {
+			return err
+		}
+		table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(table, "ALERT GROUP\tBACKEND\tMESSAGE PATH\tUSER\tNAME")
+		for _, sub := range subs {
+			fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", sub.AlertGroup, sub.Backend, sub.MessagePath, sub.Username, sub.FriendlyName)
+		}
+		table.Flush()
+	}
+	return nil
+}
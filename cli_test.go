@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_sendTestAlert(t *testing.T) {
+	oldSenders := botanistConfig.WebhookSenders
+	defer func() { botanistConfig.WebhookSenders = oldSenders }()
+	botanistConfig.WebhookSenders = []WebhookSender{{Name: "hmac", HMACSecret: "secret-key"}}
+
+	server := httptest.NewServer(authenticateWebhook(promAlertHandler))
+	defer server.Close()
+	if err := sendTestAlert([]string{"--receiver", "wakeup", "--url", server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	err := sendTestAlert([]string{"--receiver", "wakeup", "--url", server.URL, "--sender", "unknown"})
+	if err == nil || !strings.Contains(err.Error(), "unknown sender") {
+		t.Errorf("Expected unknown sender error, got %v", err)
+	}
+}
+
+func Test_localWebhookURL(t *testing.T) {
+	assertEqual(t, localWebhookURL(HTTPServerConfig{}), "http://localhost:8081/alert", "")
+	assertEqual(t, localWebhookURL(HTTPServerConfig{ListenAddress: "10.0.0.1:443", CertFile: "cert.pem"}), "https://10.0.0.1:443/alert", "")
+}
+
+func Test_manageSubscribersRunning(t *testing.T) {
+	oldClients, oldSubscriptions := botanistConfig.AdminAPIClients, subscriptions
+	defer func() { botanistConfig.AdminAPIClients, subscriptions = oldClients, oldSubscriptions }()
+	subscriptions = newFileSubscriptionStore("")
+	botanistConfig.AdminAPIClients = nil
+
+	server := httptest.NewServer(authenticateAdmin(adminAPIHandler))
+	defer server.Close()
+	err := manageSubscribers([]string{"add", "--url", server.URL, "wakeup", "spaces/jane"})
+	if err == nil || !strings.Contains(err.Error(), "adminAPIClients") {
+		t.Fatalf("Expected running botanist without admin API clients to be refused, got %v", err)
+	}
+
+	botanistConfig.AdminAPIClients = []AdminAPIClient{{Name: "provisioning", BearerToken: "secret-token"}}
+	if err := manageSubscribers([]string{"add", "--url", server.URL, "--name", "Jane", "wakeup", "spaces/jane"}); err != nil {
+		t.Fatal(err)
+	}
+	subs, err := subscriptions.Subscribers("wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(subs), 1, "")
+	assertEqual(t, subs[0].FriendlyName, "Jane", "")
+	if err := manageSubscribers([]string{"list", "--url", server.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manageSubscribers([]string{"remove", "--url", server.URL, "wakeup", "spaces/jane"}); err != nil {
+		t.Fatal(err)
+	}
+	subs, err = subscriptions.Subscribers("wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(subs), 0, "")
+}
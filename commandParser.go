@@ -17,6 +17,30 @@ func init() {
 		"welcome <user:string>": handleWelcome,
 		"annoy me about <alertgroup:string> alerts":     handleAddToAlertGroup,
 		"don't bug me about <alertgroup:string> alerts": handleDelFromAlertGroup,
+		"alerts":                                  handleListAlerts,
+		"alerts <instance:string>":                handleListAlerts,
+		"query <instance:string> (.*)":            handlePromQuery,
+		"history <alert:string>":                  handleHistory,
+		"history <alert:string> <since:string>":   handleHistory,
+		"report":                                  handleReport,
+		"report <period:string>":                  handleReport,
+		"audit":                                   handleAudit,
+		"audit <query:string>":                    handleAudit,
+		"audit <user:string> <since:string>":      handleAudit,
+		"who is on call":                          handleWhoIsOnCall,
+		"who is on call <schedule:string>":        handleWhoIsOnCall,
+		"oncall override me until <until:string>": handleOnCallOverride,
+		"oncall override me on <schedule:string> until <until:string>":                        handleOnCallOverride,
+		"oncall schedule <schedule:string> <start:string> <handoff:string> <timezone:string>": handleSchedule,
+		"oncall join <schedule:string>":                                                       handleJoinSchedule,
+		"oncall leave <schedule:string>":                                                      handleLeaveSchedule,
+		"route <alertgroup:string> alerts to oncall <schedule:string>":                        handleRouteToOnCall,
+		"quiet hours":                                  handleQuietHours,
+		"quiet hours <hours:string>":                   handleQuietHours,
+		"quiet hours <hours:string> <timezone:string>": handleQuietHours,
+		"dnd for <duration:string>":                    handleDoNotDisturb,
+		"dnd until <until:string>":                     handleDoNotDisturb,
+		"dnd off":                                      handleDoNotDisturb,
 	}
 	commandList = make(map[allot.Command]func(allot.MatchInterface, User) (*genericMessage, error))
 	for comm, handler := range commandDescription {
@@ -32,6 +56,10 @@ func handleRequest(incomingMessage *genericMessage) (*genericMessage, error) {
 		match, err := cmd.Match(request)
 
 		if err == nil {
+			commandInvocations.WithLabelValues(cmd.Text()).Inc()
+			if err := authorize(incomingMessage.Sender.getUserinfo(), cmd.Text(), requiredRole(commandRoles, cmd.Text())); err != nil {
+				return &genericMessage{ContentText: err.Error(), Thread: incomingMessage.Thread, MessagePath: incomingMessage.MessagePath}, nil
+			}
 			message, err := handler(match, incomingMessage.Sender)
 			message.Thread = incomingMessage.Thread
 			message.MessagePath = incomingMessage.MessagePath
@@ -41,6 +69,7 @@ func handleRequest(incomingMessage *genericMessage) (*genericMessage, error) {
 			return message, nil
 		}
 	}
+	commandInvocations.WithLabelValues("unknown").Inc()
 	messageText := "Your message did not match any command.\nPossible case-sensitive commands are:\n"
 	for key := range commandDescription {
 		messageText += fmt.Sprintf(" %s\n", key)
@@ -67,11 +96,66 @@ func handleWelcome(match allot.MatchInterface, User User) (*genericMessage, erro
 func handleAddToAlertGroup(match allot.MatchInterface, User User) (*genericMessage, error) {
 	alertGroup, err := match.String("alertgroup")
 	err = User.addToAlertGroup(alertGroup)
+	if err == nil {
+		auditUser(User, auditSubscribed, alertGroup, map[string]string{"messagePath": User.getUserinfo().MessagePath})
+	}
 	return &genericMessage{ContentText: fmt.Sprintf("User %s added to alert group %s", User.getUserinfo().FriendlyName, alertGroup)}, err
 }
 
 func handleDelFromAlertGroup(match allot.MatchInterface, User User) (*genericMessage, error) {
 	alertGroup, err := match.String("alertgroup")
 	err = User.delFromAlertGroup(alertGroup)
+	if err == nil {
+		auditUser(User, auditUnsubscribed, alertGroup, map[string]string{"messagePath": User.getUserinfo().MessagePath})
+	}
 	return &genericMessage{ContentText: fmt.Sprintf("User %s removed from alert group %s", User.getUserinfo().FriendlyName, alertGroup)}, err
 }
+
+func handleListAlerts(match allot.MatchInterface, User User) (*genericMessage, error) {
+	alertmanagers := currentConfig().Alertmanagers
+	if instance, err := match.String("instance"); err == nil {
+		amConfig, ok := getAlertmanagerByName(instance)
+		if !ok {
+			return &genericMessage{ContentText: fmt.Sprintf("I don't know an Alertmanager called %s", instance)}, nil
+		}
+		alertmanagers = []AlertmanagerConfig{amConfig}
+	}
+	if len(alertmanagers) == 0 {
+		return &genericMessage{ContentText: "There are no Alertmanagers configured"}, nil
+	}
+
+	var messageText string
+	for _, amConfig := range alertmanagers {
+		alerts, err := amConfig.getAlerts(ctx, nil)
+		if err != nil {
+			messageText += fmt.Sprintf("%s: I could not fetch alerts: %s\n", amConfig.displayName(), err)
+			continue
+		}
+		if len(alerts) == 0 {
+			messageText += fmt.Sprintf("%s: no alerts\n", amConfig.displayName())
+			continue
+		}
+		messageText += fmt.Sprintf("%s:\n%s", amConfig.displayName(), formatAlerts(alerts))
+	}
+	return &genericMessage{ContentText: messageText}, nil
+}
+
+func handlePromQuery(match allot.MatchInterface, User User) (*genericMessage, error) {
+	instance, err := match.String("instance")
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues identifying which Prometheus to ask"}, err
+	}
+	expr, err := match.Match(1)
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues parsing your query"}, err
+	}
+	promConfig, ok := getPrometheusByName(instance)
+	if !ok {
+		return &genericMessage{ContentText: fmt.Sprintf("I don't know a Prometheus called %s", instance)}, nil
+	}
+	value, err := promConfig.query(ctx, expr)
+	if err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("Query against %s failed: %s", instance, err)}, nil
+	}
+	return &genericMessage{ContentText: formatQueryResult(value)}, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configCheckInterval is how often the config file is checked for changes
+const configCheckInterval = 10 * time.Second
+
+var (
+	configMu sync.RWMutex
+	// channels notified after every successful reload
+	configListeners []chan struct{}
+)
+
+// currentConfig returns the active configuration.
+// The returned config must not be modified, reloads replace it as a whole
+func currentConfig() *config {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	return botanistConfig
+}
+
+// notifyConfigReload returns a channel that receives a value after every successful reload
+func notifyConfigReload() <-chan struct{} {
+	configMu.Lock()
+	defer configMu.Unlock()
+	listener := make(chan struct{}, 1)
+	configListeners = append(configListeners, listener)
+	return listener
+}
+
+// waitForReload blocks until the next reload and returns false if ctx is cancelled first
+func waitForReload(ctx context.Context, reloaded <-chan struct{}) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-reloaded:
+		return true
+	}
+}
+
+// loadConfig reads, defaults and validates the config file
+func loadConfig(path string) (*config, error) {
+	configFile, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file at %s: %s", path, err)
+	}
+	newConfig := &config{}
+	if err := yaml.Unmarshal(configFile, newConfig); err != nil {
+		return nil, fmt.Errorf("unable to parse config file: %s", err)
+	}
+	if newConfig.Database == "" {
+		newConfig.Database = "botanist.db"
+	}
+	if err := newConfig.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file: %s", err)
+	}
+	return newConfig, nil
+}
+
+// validate checks everything that would otherwise only fail once it is used
+func (c *config) validate() error {
+	names := make(map[string]bool)
+	for _, amConfig := range c.Alertmanagers {
+		if amConfig.ExternalURL == "" {
+			return fmt.Errorf("alertmanager %s has no externalURL", amConfig.Name)
+		}
+		if names[amConfig.Name] {
+			return fmt.Errorf("alertmanager %s is configured twice", amConfig.Name)
+		}
+		names[amConfig.Name] = true
+		for _, address := range amConfig.addresses() {
+			if _, err := newAPIClient(address, amConfig.HTTPClientConfig); err != nil {
+				return fmt.Errorf("alertmanager %s: %s", amConfig.Name, err)
+			}
+		}
+	}
+
+	names = make(map[string]bool)
+	for _, promConfig := range c.Prometheus {
+		if names[promConfig.Name] {
+			return fmt.Errorf("prometheus %s is configured twice", promConfig.Name)
+		}
+		names[promConfig.Name] = true
+		for _, address := range append([]string{promConfig.URL}, promConfig.Peers...) {
+			if _, err := newAPIClient(address, promConfig.HTTPClientConfig); err != nil {
+				return fmt.Errorf("prometheus %s: %s", promConfig.Name, err)
+			}
+		}
+	}
+
+	for _, sender := range c.WebhookSenders {
+		if err := sender.checkSecrets(); err != nil {
+			return fmt.Errorf("webhook sender %s: %s", sender.Name, err)
+		}
+	}
+	for _, client := range c.AdminAPIClients {
+		if err := WebhookSender(client).checkSecrets(); err != nil {
+			return fmt.Errorf("admin API client %s: %s", client.Name, err)
+		}
+	}
+
+	names = make(map[string]bool)
+	for _, source := range c.IngestSources {
+		if source.Name == "" || source.AlertName == "" {
+			return fmt.Errorf("ingest sources need a name and an alertName")
+		}
+		if names[source.Name] {
+			return fmt.Errorf("ingest source %s is configured twice", source.Name)
+		}
+		names[source.Name] = true
+		mappings := map[string]string{"alertName": source.AlertName, "status": source.Status, "summary": source.Summary, "link": source.Link}
+		for label, mapping := range source.Labels {
+			mappings["label "+label] = mapping
+		}
+		for name, mapping := range mappings {
+			if _, err := template.New(name).Parse(mapping); err != nil {
+				return fmt.Errorf("ingest source %s: invalid %s template: %s", source.Name, name, err)
+			}
+		}
+	}
+
+	if c.Dashboard.Issuer != "" {
+		if c.Dashboard.ClientID == "" || c.Dashboard.RedirectURL == "" {
+			return fmt.Errorf("dashboard needs a clientID and redirectURL")
+		}
+		if err := requireTLS(c.Dashboard.Issuer); err != nil {
+			return fmt.Errorf("dashboard issuer %s", err)
+		}
+		if _, err := readSecret(c.Dashboard.ClientSecret, c.Dashboard.ClientSecretFile); err != nil {
+			return fmt.Errorf("dashboard: %s", err)
+		}
+		if _, err := readSecret(c.Dashboard.SessionSecret, c.Dashboard.SessionSecretFile); err != nil {
+			return fmt.Errorf("dashboard: %s", err)
+		}
+	}
+	names = make(map[string]bool)
+	for _, policy := range c.EscalationPolicies {
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("escalation policy %s", err)
+		}
+		if names[policy.Name] {
+			return fmt.Errorf("escalation policy %s is configured twice", policy.Name)
+		}
+		names[policy.Name] = true
+	}
+	for i, reminderConfig := range c.Reminders {
+		if err := reminderConfig.validate(); err != nil {
+			return fmt.Errorf("reminder %d: %s", i+1, err)
+		}
+	}
+	names = make(map[string]bool)
+	for _, schedule := range c.Schedules {
+		if err := schedule.validate(); err != nil {
+			return fmt.Errorf("schedule %s", err)
+		}
+		if names[strings.ToLower(schedule.Name)] {
+			return fmt.Errorf("schedule %s is configured twice", schedule.Name)
+		}
+		names[strings.ToLower(schedule.Name)] = true
+	}
+	if err := c.Permissions.validate(); err != nil {
+		return fmt.Errorf("permissions: %s", err)
+	}
+	if c.Report.AlertGroup != "" {
+		if _, err := nextReportTime(c.Report, time.Now()); err != nil {
+			return fmt.Errorf("report: %s", err)
+		}
+	}
+	if c.HistoryRetention < 0 {
+		return fmt.Errorf("historyRetention must not be negative")
+	}
+	if c.Outbox.Workers < 0 || c.Outbox.MaxAttempts < 0 {
+		return fmt.Errorf("outbox workers and maxAttempts must not be negative")
+	}
+	switch c.Subscriptions.Type {
+	case "", "bolt", "file", "sql":
+	default:
+		return fmt.Errorf("unknown subscription store type %q", c.Subscriptions.Type)
+	}
+	return nil
+}
+
+// restartRequired lists the sections that changed but are only read on startup
+func restartRequired(oldConfig, newConfig *config) []string {
+	var sections []string
+	if oldConfig.Hangouts.CredentialsFile != newConfig.Hangouts.CredentialsFile ||
+		oldConfig.Hangouts.Project != newConfig.Hangouts.Project ||
+		oldConfig.Hangouts.PsSubscription != newConfig.Hangouts.PsSubscription {
+		sections = append(sections, "hangouts")
+	}
+	if oldConfig.Database != newConfig.Database {
+		sections = append(sections, "database")
+	}
+	if !reflect.DeepEqual(oldConfig.HTTP, newConfig.HTTP) {
+		sections = append(sections, "http")
+	}
+	if oldConfig.Outbox.Workers != newConfig.Outbox.Workers {
+		sections = append(sections, "outbox.workers")
+	}
+	if !reflect.DeepEqual(oldConfig.Subscriptions, newConfig.Subscriptions) {
+		sections = append(sections, "subscriptions")
+	}
+	return sections
+}
+
+// reloadConfig replaces the active configuration if the config file is valid.
+// On error the old configuration stays active
+func reloadConfig(path string) error {
+	newConfig, err := loadConfig(path)
+	if err != nil {
+		configReloads.WithLabelValues("failure").Inc()
+		configLastReloadSuccessful.Set(0)
+		return err
+	}
+
+	configMu.Lock()
+	oldConfig := botanistConfig
+	botanistConfig = newConfig
+	listeners := configListeners
+	configMu.Unlock()
+
+	configReloads.WithLabelValues("success").Inc()
+	configLastReloadSuccessful.Set(1)
+	configLastReloadTime.SetToCurrentTime()
+	if sections := restartRequired(oldConfig, newConfig); len(sections) > 0 {
+		log.Warnf("Changes to %v only take effect after a restart", sections)
+	}
+	for _, listener := range listeners {
+		select {
+		case listener <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// watchConfig reloads the config file on SIGHUP and whenever it changes
+func watchConfig(ctx context.Context, path string) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var lastModified time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModified = info.ModTime()
+	}
+	ticker := time.NewTicker(configCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			log.Infoln("Received SIGHUP, reloading configuration")
+			if info, err := os.Stat(path); err == nil {
+				lastModified = info.ModTime()
+			}
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
+			log.Infof("Config file %s changed, reloading configuration", path)
+		}
+		if err := reloadConfig(path); err != nil {
+			log.Errorf("Keeping the current configuration: %s", err)
+			continue
+		}
+		log.Infoln("Configuration reloaded")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_reloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "botanist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "botanist.conf")
+
+	oldConfig := botanistConfig
+	defer func() { botanistConfig = oldConfig }()
+	reloaded := notifyConfigReload()
+
+	valid := []byte("ingestSources:\n  - name: ci\n    alertName: \"{{ .job }}\"\n")
+	if err := ioutil.WriteFile(path, valid, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	<-reloaded
+	_, ok := getIngestSource("ci")
+	assertEqual(t, ok, true, "")
+	assertEqual(t, currentConfig().Database, "botanist.db", "")
+
+	// An invalid config keeps the current one
+	invalid := []byte("ingestSources:\n  - name: build\n    alertName: \"{{ .job \"\n")
+	if err := ioutil.WriteFile(path, invalid, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := reloadConfig(path); err == nil {
+		t.Error("Expected invalid template to be rejected")
+	}
+	_, ok = getIngestSource("ci")
+	assertEqual(t, ok, true, "")
+	_, ok = getIngestSource("build")
+	assertEqual(t, ok, false, "")
+}
+
+func Test_restartRequired(t *testing.T) {
+	oldConfig := &config{Database: "botanist.db"}
+	newConfig := &config{Database: "other.db", Report: ReportConfig{AlertGroup: "oncall"}}
+	sections := restartRequired(oldConfig, newConfig)
+	assertEqual(t, len(sections), 1, "")
+	assertEqual(t, sections[0], "database", "")
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const dashboardPrefix = "/dashboard/"
+
+// DashboardConfig configures the web dashboard and the OIDC login protecting it
+type DashboardConfig struct {
+	// OIDC issuer, e.g. https://accounts.google.com. The dashboard is disabled if unset
+	Issuer           string `yaml:"issuer,omitempty"`
+	ClientID         string `yaml:"clientID,omitempty"`
+	ClientSecret     string `yaml:"clientSecret,omitempty"`
+	ClientSecretFile string `yaml:"clientSecretFile,omitempty"`
+	// External URL of botanist's /dashboard/callback, registered with the issuer
+	RedirectURL string `yaml:"redirectURL,omitempty"`
+	// Only users with an email address in these domains may log in. Everyone the issuer knows if empty
+	AllowedDomains []string `yaml:"allowedDomains,omitempty"`
+	// Key session cookies are signed with. Sessions do not survive restarts if unset
+	SessionSecret     string `yaml:"sessionSecret,omitempty"`
+	SessionSecretFile string `yaml:"sessionSecretFile,omitempty"`
+	// How many of the most recent notifications are shown, defaults to 50
+	Notifications int `yaml:"notifications,omitempty"`
+}
+
+// dashboardGroup is an alert group and its subscribers
+type dashboardGroup struct {
+	Name        string
+	Subscribers []Subscription
+}
+
+// dashboardSilence is an active silence created through botanist
+type dashboardSilence struct {
+	Alertmanager string
+	ID           string
+	Matchers     string
+	CreatedBy    string
+	EndsAt       time.Time
+}
+
+type dashboardData struct {
+	User       *dashboardSession
+	Groups     []dashboardGroup
+	Deliveries []deliveryLogEntry
+	Silences   []dashboardSilence
+	Health     []readinessResult
+	Errors     []string
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Botanist</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.3em 1em 0.3em 0; border-bottom: 1px solid #ddd; vertical-align: top; }
+.ok, .delivered { color: #2a7d2a; }
+.failed, .error { color: #c62828; }
+.pending, .retrying { color: #b26a00; }
+header { display: flex; justify-content: space-between; }
+</style>
+</head>
+<body>
+<header><h1>Botanist</h1><p>{{ .User.Name }} &middot; <a href="logout">Log out</a></p></header>
+{{ range .Errors }}<p class="error">{{ . }}</p>{{ end }}
+
+<h2>Health</h2>
+<table>
+{{ range .Health }}<tr><td>{{ .Name }}</td>{{ if .Err }}<td class="error">{{ .Err }}</td>{{ else }}<td class="ok">ok</td>{{ end }}</tr>
+{{ end }}</table>
+
+<h2>Alert groups</h2>
+<table>
+<tr><th>Alert group</th><th>Subscribers</th></tr>
+{{ range .Groups }}<tr><td>{{ .Name }}</td><td>{{ range .Subscribers }}{{ .FriendlyName }} ({{ .Backend }} {{ .MessagePath }})<br>{{ end }}</td></tr>
+{{ else }}<tr><td colspan="2">Nobody is subscribed to any alert group</td></tr>
+{{ end }}</table>
+
+<h2>Recent notifications</h2>
+<table>
+<tr><th>Sent</th><th>Message</th><th>Recipient</th><th>Status</th><th>Attempts</th></tr>
+{{ range .Deliveries }}<tr><td>{{ time .CreatedAt }}</td><td>{{ .Summary }}</td><td>{{ .Recipient }} ({{ .Backend }})</td>
+<td class="{{ .Status }}">{{ .Status }}{{ if .LastError }}: {{ .LastError }}{{ end }}</td><td>{{ .Attempts }}</td></tr>
+{{ else }}<tr><td colspan="5">No notifications sent yet</td></tr>
+{{ end }}</table>
+
+<h2>Active silences</h2>
+<table>
+<tr><th>Alertmanager</th><th>Matchers</th><th>Created by</th><th>Ends</th></tr>
+{{ range .Silences }}<tr><td>{{ .Alertmanager }}</td><td>{{ .Matchers }}</td><td>{{ .CreatedBy }}</td><td>{{ time .EndsAt }}</td></tr>
+{{ else }}<tr><td colspan="4">No active silences created through botanist</td></tr>
+{{ end }}</table>
+</body>
+</html>
+`))
+
+// botanistSilences returns the active silences created through botanist on all configured Alertmanagers
+func botanistSilences(ctx context.Context) ([]dashboardSilence, []string) {
+	var silences []dashboardSilence
+	var errors []string
+	for _, amConfig := range currentConfig().Alertmanagers {
+		amSilences, err := amConfig.getSilences(ctx, nil)
+		if err != nil {
+			errors = append(errors, "Could not fetch silences of "+amConfig.displayName()+": "+err.Error())
+			continue
+		}
+		for _, silence := range amSilences {
+			if silence.Comment != silenceComment || silence.Status == nil || silence.Status.State != "active" {
+				continue
+			}
+			var matchers []string
+			for _, matcher := range silence.Matchers {
+				matchers = append(matchers, matcher.Name+"="+matcher.Value)
+			}
+			sort.Strings(matchers)
+			silences = append(silences, dashboardSilence{
+				Alertmanager: amConfig.displayName(),
+				ID:           silence.ID,
+				Matchers:     strings.Join(matchers, ", "),
+				CreatedBy:    silence.CreatedBy,
+				EndsAt:       silence.EndsAt,
+			})
+		}
+	}
+	sort.Slice(silences, func(i, j int) bool { return silences[i].EndsAt.Before(silences[j].EndsAt) })
+	return silences, errors
+}
+
+func dashboardPage(w http.ResponseWriter, r *http.Request, session *dashboardSession) {
+	data := dashboardData{User: session, Health: checkReadiness()}
+
+	subs, err := subscriptions.All()
+	if err != nil {
+		data.Errors = append(data.Errors, "Could not read subscriptions: "+err.Error())
+	}
+	for _, sub := range subs {
+		if len(data.Groups) == 0 || data.Groups[len(data.Groups)-1].Name != sub.AlertGroup {
+			data.Groups = append(data.Groups, dashboardGroup{Name: sub.AlertGroup})
+		}
+		group := &data.Groups[len(data.Groups)-1]
+		group.Subscribers = append(group.Subscribers, sub)
+	}
+
+	count := currentConfig().Dashboard.Notifications
+	if count == 0 {
+		count = 50
+	}
+	data.Deliveries, err = recentDeliveries(count)
+	if err != nil {
+		data.Errors = append(data.Errors, "Could not read recent notifications: "+err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	var silenceErrors []string
+	data.Silences, silenceErrors = botanistSilences(ctx)
+	data.Errors = append(data.Errors, silenceErrors...)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Errorf("Failed to render dashboard: %s", err)
+	}
+}
+
+// dashboardHandler serves the dashboard and its login to users authenticated by the OIDC issuer
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	dashboardConfig := currentConfig().Dashboard
+	if dashboardConfig.Issuer == "" {
+		http.NotFound(w, r)
+		return
+	}
+	switch strings.TrimPrefix(r.URL.Path, dashboardPrefix) {
+	case "login":
+		loginHandler(w, r)
+	case "callback":
+		callbackHandler(w, r)
+	case "logout":
+		logoutHandler(w, r)
+	case "":
+		session := dashboardConfig.currentSession(r)
+		if session == nil || !dashboardConfig.allowed(session) {
+			http.Redirect(w, r, dashboardPrefix+"login", http.StatusFound)
+			return
+		}
+		dashboardPage(w, r, session)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestIssuer is a minimal OIDC provider issuing ID tokens for jane@example.com
+func newTestIssuer(t *testing.T) *httptest.Server {
+	var issuer *httptest.Server
+	var nonce string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{Issuer: issuer.URL, AuthorizationEndpoint: issuer.URL + "/auth", TokenEndpoint: issuer.URL + "/token"})
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		nonce = r.URL.Query().Get("nonce")
+		http.Redirect(w, r, r.URL.Query().Get("redirect_uri")+"?code=secret-code&state="+r.URL.Query().Get("state"), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := json.Marshal(map[string]interface{}{
+			"iss": issuer.URL, "sub": "1", "aud": "botanist", "exp": time.Now().Add(time.Minute).Unix(),
+			"nonce": nonce, "email": "jane@example.com", "name": "Jane",
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "token", "token_type": "Bearer",
+			"id_token": "e30." + base64.RawURLEncoding.EncodeToString(claims) + ".",
+		})
+	})
+	issuer = httptest.NewServer(mux)
+	return issuer
+}
+
+func Test_dashboardLogin(t *testing.T) {
+	issuer := newTestIssuer(t)
+	defer issuer.Close()
+	oldDashboard := botanistConfig.Dashboard
+	defer func() { botanistConfig.Dashboard = oldDashboard }()
+	botanistConfig.Dashboard = DashboardConfig{
+		Issuer:         issuer.URL,
+		ClientID:       "botanist",
+		RedirectURL:    "http://botanist.example.com/dashboard/callback",
+		AllowedDomains: []string{"example.com"},
+		SessionSecret:  "secret",
+	}
+
+	var cookies []*http.Cookie
+	get := func(target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rr := httptest.NewRecorder()
+		dashboardHandler(rr, req)
+		cookies = append(cookies, rr.Result().Cookies()...)
+		return rr
+	}
+
+	rr := get("/dashboard/")
+	assertEqual(t, rr.Header().Get("Location"), "/dashboard/login", "")
+	rr = get("/dashboard/login")
+	assertEqual(t, rr.Code, http.StatusFound, rr.Body.String())
+
+	// Let the issuer redirect back to botanist
+	resp, err := (&http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}).Get(rr.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = get("/dashboard/callback?" + callback.RawQuery)
+	assertEqual(t, rr.Code, http.StatusFound, rr.Body.String())
+
+	rr = get("/dashboard/")
+	assertEqual(t, rr.Code, http.StatusOK, "")
+	assertEqual(t, strings.Contains(rr.Body.String(), "Jane"), true, rr.Body.String())
+
+	// A tampered session is rejected
+	cookies = []*http.Cookie{{Name: sessionCookie, Value: fmt.Sprintf("%s.%s", "e30", "AAAA")}}
+	assertEqual(t, get("/dashboard/").Code, http.StatusFound, "")
+}
+
+func Test_parseIDToken(t *testing.T) {
+	provider := &oidcDiscovery{Issuer: "https://idp.example.com"}
+	now := time.Unix(1000, 0)
+	token := func(claims string) string {
+		return "e30." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + "."
+	}
+	_, err := parseIDToken(token(`{"iss": "https://idp.example.com", "aud": ["other", "botanist"], "exp": 2000, "nonce": "n"}`), provider, "botanist", "n", now)
+	assertEqual(t, err, nil, "")
+	_, err = parseIDToken(token(`{"iss": "https://idp.example.com", "aud": "botanist", "exp": 500, "nonce": "n"}`), provider, "botanist", "n", now)
+	assertEqual(t, err != nil, true, "expired token accepted")
+	_, err = parseIDToken(token(`{"iss": "https://idp.example.com", "aud": "botanist", "exp": 2000, "nonce": "replayed"}`), provider, "botanist", "n", now)
+	assertEqual(t, err != nil, true, "wrong nonce accepted")
+	_, err = parseIDToken(token(`{"iss": "https://evil.example.com", "aud": "botanist", "exp": 2000, "nonce": "n"}`), provider, "botanist", "n", now)
+	assertEqual(t, err != nil, true, "wrong issuer accepted")
+}
+
+func Test_requireTLS(t *testing.T) {
+	for endpoint, allowed := range map[string]bool{
+		"https://idp.example.com":      true,
+		"http://127.0.0.1:8080/token":  true,
+		"http://localhost:8080":        true,
+		"http://[::1]:8080":            true,
+		"http://idp.example.com/token": false,
+		"http://10.0.0.1/token":        false,
+		"ftp://idp.example.com":        false,
+	} {
+		assertEqual(t, requireTLS(endpoint) == nil, allowed, endpoint)
+	}
+}
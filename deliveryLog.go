@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// deliveryLogBucket holds the status of the most recent message deliveries, keyed like the outbox
+var deliveryLogBucket = []byte("deliveries")
+
+// maxDeliveryLog is how many deliveries are kept in the log
+const maxDeliveryLog = 1000
+
+// deliveryLogCountKey tracks the number of delivery log entries in the metaBucket,
+// as Stats do not cover changes of an open transaction
+var deliveryLogCountKey = []byte("deliveryLogCount")
+
+// Delivery states
+const (
+	deliveryPending   = "pending"
+	deliveryRetrying  = "retrying"
+	deliveryDelivered = "delivered"
+	deliveryFailed    = "failed"
+)
+
+// deliveryLogEntry is the outcome of delivering one message to one recipient
+type deliveryLogEntry struct {
+	ID        uint64    `json:"id"`
+	Backend   string    `json:"backend"`
+	Recipient string    `json:"recipient"`
+	Summary   string    `json:"summary"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// messageSummary is a one line description of a message
+func messageSummary(msg *genericMessage) string {
+	var parts []string
+	for _, text := range []string{msg.HeaderText, msg.FooterText, msg.ContentText} {
+		if text = strings.TrimSpace(text); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	summary := strings.Join(parts, " - ")
+	if firstLine := strings.Index(summary, "\n"); firstLine >= 0 {
+		summary = summary[:firstLine] + " ..."
+	}
+	return summary
+}
+
+// logDelivery records the current status of a delivery and drops the oldest entries
+func logDelivery(tx *bolt.Tx, delivery *outboxDelivery, status string) error {
+	bucket := tx.Bucket(deliveryLogBucket)
+	data, err := json.Marshal(deliveryLogEntry{
+		ID:        delivery.ID,
+		Backend:   delivery.Backend,
+		Recipient: delivery.Recipient.FriendlyName,
+		Summary:   messageSummary(delivery.Message),
+		CreatedAt: delivery.CreatedAt,
+		UpdatedAt: time.Now(),
+		Status:    status,
+		Attempts:  delivery.Attempts,
+		LastError: delivery.LastError,
+	})
+	if err != nil {
+		return err
+	}
+	key := sequenceKey(delivery.ID)
+	if bucket.Get(key) != nil {
+		return bucket.Put(key, data)
+	}
+	count, err := deliveryLogCount(tx)
+	if err != nil {
+		return err
+	}
+	if err := bucket.Put(key, data); err != nil {
+		return err
+	}
+	count++
+	cursor := bucket.Cursor()
+	for key, _ := cursor.First(); key != nil && count > maxDeliveryLog; key, _ = cursor.First() {
+		if err := cursor.Delete(); err != nil {
+			return err
+		}
+		count--
+	}
+	return tx.Bucket(metaBucket).Put(deliveryLogCountKey, sequenceKey(uint64(count)))
+}
+
+// deliveryLogCount returns the number of delivery log entries, counting them if they were not tracked yet
+func deliveryLogCount(tx *bolt.Tx) (int, error) {
+	if data := tx.Bucket(metaBucket).Get(deliveryLogCountKey); data != nil {
+		return int(binary.BigEndian.Uint64(data)), nil
+	}
+	count := 0
+	err := tx.Bucket(deliveryLogBucket).ForEach(func(_, _ []byte) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// recentDeliveries returns the last count deliveries, newest first
+func recentDeliveries(count int) ([]deliveryLogEntry, error) {
+	var entries []deliveryLogEntry
+	if stateDB == nil {
+		return entries, nil
+	}
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(deliveryLogBucket).Cursor()
+		for key, data := cursor.Last(); key != nil && len(entries) < count; key, data = cursor.Prev() {
+			var entry deliveryLogEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_logDeliveryTrim(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	recipient := &Userinfo{MessagePath: "spaces/jane", FriendlyName: "Jane"}
+	err := stateDB.Update(func(tx *bolt.Tx) error {
+		for id := uint64(1); id <= maxDeliveryLog+5; id++ {
+			delivery := &outboxDelivery{ID: id, Backend: "hangouts", Recipient: recipient, Message: &genericMessage{ContentText: "hello"}}
+			if err := logDelivery(tx, delivery, deliveryPending); err != nil {
+				return err
+			}
+			// Status updates do not add entries
+			if err := logDelivery(tx, delivery, deliveryDelivered); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateDB.View(func(tx *bolt.Tx) error {
+		assertEqual(t, tx.Bucket(deliveryLogBucket).Stats().KeyN, maxDeliveryLog, "")
+		count, _ := deliveryLogCount(tx)
+		assertEqual(t, count, maxDeliveryLog, "")
+		return nil
+	})
+	entries, err := recentDeliveries(maxDeliveryLog + 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(entries), maxDeliveryLog, "")
+	assertEqual(t, entries[0].ID, uint64(maxDeliveryLog+5), "")
+	assertEqual(t, entries[len(entries)-1].ID, uint64(6), "the oldest entries are dropped")
+	assertEqual(t, entries[0].Status, deliveryDelivered, "")
+}
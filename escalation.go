@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/common/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// escalationBucket holds the escalations of alert groups nobody reacted to yet
+var escalationBucket = []byte("escalations")
+
+// escalationCheckInterval is how often due escalations are sent
+const escalationCheckInterval = 30 * time.Second
+
+// EscalationPolicy notifies further alert groups while alerts of its receivers are not acknowledged or silenced
+type EscalationPolicy struct {
+	Name string `yaml:"name"`
+	// Receivers (alertGroups) the policy applies to
+	Receivers []string `yaml:"receivers"`
+	// Only escalate alerts with all of these common labels, e.g. severity: critical
+	Matchers map[string]string `yaml:"matchers,omitempty"`
+	// Levels in the order they are notified
+	Levels []EscalationLevel `yaml:"levels"`
+}
+
+// EscalationLevel is notified if the alert was not acknowledged After it was first sent
+type EscalationLevel struct {
+	After time.Duration `yaml:"after"`
+	// Alert group notified, subscribe phones or managers to it
+	AlertGroup string `yaml:"alertGroup"`
+}
+
+// escalation tracks a firing alert group of a receiver with an escalation policy
+type escalation struct {
+	Key          string            `json:"key"`
+	Policy       string            `json:"policy"`
+	Receiver     string            `json:"receiver"`
+	Labels       map[string]string `json:"labels"`
+	Alertmanager string            `json:"alertmanager,omitempty"`
+	Message      *genericMessage   `json:"message"`
+	StartedAt    time.Time         `json:"startedAt"`
+	// Index of the next level to notify
+	Level int `json:"level"`
+}
+
+func (policy EscalationPolicy) validate() error {
+	if policy.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if len(policy.Receivers) == 0 {
+		return fmt.Errorf("%s: receivers are required", policy.Name)
+	}
+	if len(policy.Levels) == 0 {
+		return fmt.Errorf("%s: levels are required", policy.Name)
+	}
+	var previous time.Duration
+	for _, level := range policy.Levels {
+		if level.AlertGroup == "" {
+			return fmt.Errorf("%s: alertGroup is required for every level", policy.Name)
+		}
+		if level.After <= previous {
+			return fmt.Errorf("%s: after has to increase with every level", policy.Name)
+		}
+		previous = level.After
+	}
+	return nil
+}
+
+// appliesTo tells if the policy escalates alerts of the receiver with these common labels
+func (policy EscalationPolicy) appliesTo(receiver string, labels map[string]string) bool {
+	for key, value := range policy.Matchers {
+		if labels[key] != value {
+			return false
+		}
+	}
+	for _, policyReceiver := range policy.Receivers {
+		if policyReceiver == receiver {
+			return true
+		}
+	}
+	return false
+}
+
+// escalationPolicyFor returns the first policy applying to alerts of a receiver
+func escalationPolicyFor(receiver string, labels map[string]string) (EscalationPolicy, bool) {
+	for _, policy := range currentConfig().EscalationPolicies {
+		if policy.appliesTo(receiver, labels) {
+			return policy, true
+		}
+	}
+	return EscalationPolicy{}, false
+}
+
+func getEscalationPolicy(name string) (EscalationPolicy, bool) {
+	for _, policy := range currentConfig().EscalationPolicies {
+		if policy.Name == name {
+			return policy, true
+		}
+	}
+	return EscalationPolicy{}, false
+}
+
+func putEscalation(bucket *bolt.Bucket, esc *escalation) error {
+	data, err := json.Marshal(esc)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(esc.Key), data)
+}
+
+// escalationKey identifies the alert group of a webhook. Alertmanager's group key stays the same
+// while alerts join or leave the group and change its common labels
+func escalationKey(msg *notify.WebhookMessage) string {
+	if msg.GroupKey == "" {
+		return alertGroupKey(msg.Receiver, msg.CommonLabels)
+	}
+	return msg.Receiver + "/" + msg.GroupKey
+}
+
+// trackEscalation starts escalating a firing alert group if its receiver has a policy
+// and stops once it resolved. Alert groups that are already escalating are left alone
+func trackEscalation(msg *notify.WebhookMessage, message *genericMessage) error {
+	if stateDB == nil {
+		return nil
+	}
+	key := escalationKey(msg)
+	if msg.Status == string(model.AlertResolved) {
+		return stateDB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(escalationBucket).Delete([]byte(key))
+		})
+	}
+	policy, ok := escalationPolicyFor(msg.Receiver, msg.CommonLabels)
+	if !ok {
+		return nil
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(escalationBucket)
+		if bucket.Get([]byte(key)) != nil {
+			return nil
+		}
+		log.Infof("Escalating alert group %s of %s with policy %s unless acknowledged", key, msg.Receiver, policy.Name)
+		return putEscalation(bucket, &escalation{
+			Key:          key,
+			Policy:       policy.Name,
+			Receiver:     msg.Receiver,
+			Labels:       msg.CommonLabels,
+			Alertmanager: msg.ExternalURL,
+			Message:      message,
+			StartedAt:    time.Now(),
+		})
+	})
+}
+
+// stopEscalation ends the escalation of an alert group, e.g. because somebody acknowledged it
+func stopEscalation(key, reason string) error {
+	if stateDB == nil {
+		return nil
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(escalationBucket)
+		if bucket.Get([]byte(key)) == nil {
+			return nil
+		}
+		log.Infof("Stopped escalating %s: %s", key, reason)
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// stopEscalations ends all escalations of alerts carrying the matchers' labels, e.g. because they were silenced
+func stopEscalations(matchers map[string]string, reason string) error {
+	if stateDB == nil {
+		return nil
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(escalationBucket)
+		var stopped [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			esc := &escalation{}
+			if err := json.Unmarshal(data, esc); err != nil {
+				return err
+			}
+			for name, value := range matchers {
+				if esc.Labels[name] != value {
+					return nil
+				}
+			}
+			log.Infof("Stopped escalating %s: %s", esc.Key, reason)
+			stopped = append(stopped, append([]byte(nil), key...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range stopped {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// suppressed tells if the Alertmanager no longer notifies about the escalated alerts,
+// because they were silenced or resolved outside of botanist
+func (esc *escalation) suppressed(ctx context.Context) bool {
+	suppressed, err := alertsSuppressed(ctx, esc.Alertmanager, esc.Labels)
+	if err != nil {
+		log.Warnf("Could not check whether %s is still active, escalating anyway: %s", esc.Key, err)
+		return false
+	}
+	return suppressed
+}
+
+// escalationMessage is the alert as sent to a level
+func (esc *escalation) escalationMessage(level EscalationLevel) *genericMessage {
+	message := *esc.Message
+	message.HeaderText = "Escalated: " + message.HeaderText
+	message.FooterText += fmt.Sprintf(" - not acknowledged after %s", level.After)
+	return &message
+}
+
+// escalateDue notifies the levels of all escalations that are due at now
+func escalateDue(ctx context.Context, now time.Time) error {
+	var due []*escalation
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(escalationBucket).ForEach(func(_, data []byte) error {
+			esc := &escalation{}
+			if err := json.Unmarshal(data, esc); err != nil {
+				return err
+			}
+			due = append(due, esc)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, esc := range due {
+		policy, ok := getEscalationPolicy(esc.Policy)
+		if !ok || esc.Level >= len(policy.Levels) {
+			err = stateDB.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket(escalationBucket).Delete([]byte(esc.Key))
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		level := policy.Levels[esc.Level]
+		if esc.StartedAt.Add(level.After).After(now) {
+			continue
+		}
+		if esc.suppressed(ctx) {
+			if err := stopEscalations(esc.Labels, "silenced or resolved in Alertmanager"); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Infof("Escalating %s to level %d (%s) of policy %s", esc.Key, esc.Level+1, level.AlertGroup, policy.Name)
+		escalationsSent.WithLabelValues(policy.Name, fmt.Sprint(esc.Level+1)).Inc()
+		message := esc.escalationMessage(level)
+		for user := range getHangoutsUsersForAlertGroup(level.AlertGroup) {
+			if err := notifyUser(user, message, currentConfig().QuietHours.critical(esc.Labels)); err != nil {
+				log.Errorf("Failed to queue escalation for %s: %s", user.getUserinfo().FriendlyName, err)
+			}
+		}
+		esc.Level++
+		err = stateDB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(escalationBucket)
+			// Stop if the escalation was acknowledged or resolved in the meantime
+			if bucket.Get([]byte(esc.Key)) == nil {
+				return nil
+			}
+			return putEscalation(bucket, esc)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startEscalationScheduler sends due escalations until ctx is cancelled
+func startEscalationScheduler(ctx context.Context) {
+	runEvery(ctx, escalationCheckInterval, func(now time.Time) {
+		if err := escalateDue(ctx, now); err != nil {
+			log.Errorf("Failed to escalate alerts: %s", err)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/template"
+	bolt "go.etcd.io/bbolt"
+)
+
+func countEscalations(t *testing.T) int {
+	var count int
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(escalationBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func Test_escalation(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldConfig, oldSubscriptions := botanistConfig, subscriptions
+	defer func() { botanistConfig, subscriptions = oldConfig, oldSubscriptions }()
+	subscriptions = newFileSubscriptionStore("")
+	botanistConfig = &config{EscalationPolicies: []EscalationPolicy{{
+		Name:      "critical",
+		Receivers: []string{"wakeup"},
+		Matchers:  map[string]string{"severity": "critical"},
+		Levels: []EscalationLevel{
+			{After: 15 * time.Minute, AlertGroup: "secondary"},
+			{After: 30 * time.Minute, AlertGroup: "managers"},
+		},
+	}}}
+	if err := botanistConfig.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, group := range []string{"secondary", "managers"} {
+		if err := subscriptions.Subscribe(Subscription{AlertGroup: group, Backend: "hangouts", Userinfo: Userinfo{MessagePath: "spaces/" + group}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	warning := &notify.WebhookMessage{Data: &template.Data{Receiver: "wakeup", Status: "firing", CommonLabels: template.KV{"alertname": "Disk", "severity": "warning"}}}
+	firing := &notify.WebhookMessage{Data: &template.Data{Receiver: "wakeup", Status: "firing", CommonLabels: template.KV{"alertname": "Down", "severity": "critical"}}}
+	message := &genericMessage{HeaderText: "Prometheus alert"}
+	for _, msg := range []*notify.WebhookMessage{warning, firing, firing} {
+		if err := trackEscalation(msg, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertEqual(t, countEscalations(t), 1, "only critical alerts are escalated, once")
+
+	now := time.Now()
+	if err := escalateDue(context.Background(), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 0, "not due yet")
+	if err := escalateDue(context.Background(), now.Add(16*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "first level")
+	if err := escalateDue(context.Background(), now.Add(17*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "first level is only notified once")
+
+	if err := stopEscalations(map[string]string{"alertname": "Down"}, "silenced"); err != nil {
+		t.Fatal(err)
+	}
+	if err := escalateDue(context.Background(), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "silenced alerts are not escalated further")
+
+	if err := trackEscalation(firing, message); err != nil {
+		t.Fatal(err)
+	}
+	resolved := &notify.WebhookMessage{Data: &template.Data{Receiver: "wakeup", Status: "resolved", CommonLabels: firing.CommonLabels}}
+	if err := trackEscalation(resolved, message); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, countEscalations(t), 0, "resolved alerts are not escalated")
+
+	// A second alert joining the group changes the common labels, but not the group key
+	groupKey := "{}:{alertname=\"Down\"}"
+	first := &notify.WebhookMessage{GroupKey: groupKey, Data: &template.Data{Receiver: "wakeup", Status: "firing", CommonLabels: template.KV{"alertname": "Down", "instance": "host1", "severity": "critical"}}}
+	both := &notify.WebhookMessage{GroupKey: groupKey, Data: &template.Data{Receiver: "wakeup", Status: "firing", CommonLabels: firing.CommonLabels}}
+	for _, msg := range []*notify.WebhookMessage{first, both} {
+		if err := trackEscalation(msg, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertEqual(t, countEscalations(t), 1, "the alert group is escalated once")
+	resolved = &notify.WebhookMessage{GroupKey: groupKey, Data: &template.Data{Receiver: "wakeup", Status: "resolved", CommonLabels: template.KV{"alertname": "Down", "instance": "host2", "severity": "critical"}}}
+	if err := trackEscalation(resolved, message); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, countEscalations(t), 0, "the alert group resolved")
+}
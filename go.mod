@@ -3,11 +3,13 @@ module gitlab.pb.local/cblum/botanist
 require (
 	cloud.google.com/go v0.36.0
 	github.com/gogo/protobuf v1.2.1 // indirect
+	github.com/lib/pq v1.0.0
 	github.com/prometheus/alertmanager v0.16.1
 	github.com/prometheus/client_golang v0.9.2
 	github.com/prometheus/common v0.0.0-20181126121408-4724e9255275
 	github.com/sbstjn/allot v0.0.0-20161025071122-1f2349af5ccd
 	github.com/sirupsen/logrus v1.3.0
+	go.etcd.io/bbolt v1.3.5
 	golang.org/x/net v0.0.0-20190206173232-65e2d4e15006 // indirect
 	golang.org/x/oauth2 v0.0.0-20190211225200-5f6b76b7c9dd
 	google.golang.org/api v0.1.0
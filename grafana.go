@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/template"
+)
+
+const (
+	grafanaIconURL = "https://raw.githubusercontent.com/grafana/grafana/master/public/img/grafana_icon.svg"
+	// Path of the Alertmanager compatible API of Grafana's built-in Alertmanager
+	grafanaAlertmanagerPath = "/api/alertmanager/grafana"
+)
+
+// grafanaWebhookMessage is what Grafana unified alerting sends to webhook contact points
+type grafanaWebhookMessage struct {
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"`
+	OrgID             int64             `json:"orgId"`
+	Alerts            []grafanaAlert    `json:"alerts"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Title             string            `json:"title"`
+	Message           string            `json:"message"`
+}
+
+type grafanaAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+	SilenceURL   string            `json:"silenceURL"`
+	DashboardURL string            `json:"dashboardURL"`
+	PanelURL     string            `json:"panelURL"`
+	ValueString  string            `json:"valueString"`
+}
+
+// toWebhookMessage normalizes the Grafana payload into an Alertmanager webhook.
+// ExternalURL points to Grafana's Alertmanager compatible API so that silences end up in Grafana
+func (grafanaMsg grafanaWebhookMessage) toWebhookMessage() *notify.WebhookMessage {
+	data := &template.Data{
+		Receiver:          grafanaMsg.Receiver,
+		Status:            grafanaMsg.Status,
+		GroupLabels:       grafanaMsg.GroupLabels,
+		CommonLabels:      grafanaMsg.CommonLabels,
+		CommonAnnotations: grafanaMsg.CommonAnnotations,
+		ExternalURL:       strings.TrimSuffix(grafanaMsg.ExternalURL, "/") + grafanaAlertmanagerPath,
+	}
+	for _, alert := range grafanaMsg.Alerts {
+		annotations := template.KV(alert.Annotations)
+		if annotations == nil {
+			annotations = template.KV{}
+		}
+		if annotations["summary"] == "" && alert.ValueString != "" {
+			annotations["summary"] = alert.ValueString
+		}
+		link := alert.PanelURL
+		if link == "" {
+			link = alert.GeneratorURL
+		}
+		data.Alerts = append(data.Alerts, template.Alert{
+			Status:       alert.Status,
+			Labels:       alert.Labels,
+			Annotations:  annotations,
+			StartsAt:     alert.StartsAt,
+			EndsAt:       alert.EndsAt,
+			GeneratorURL: link,
+		})
+	}
+	return &notify.WebhookMessage{Data: data, Version: grafanaMsg.Version, GroupKey: grafanaMsg.GroupKey}
+}
+
+func grafanaAlertHandler(w http.ResponseWriter, r *http.Request) {
+	var grafanaMsg grafanaWebhookMessage
+
+	reqLog := log.WithField("remote_addr", r.RemoteAddr)
+	if r.Method != http.MethodPost {
+		reqLog.Errorf("Method %s not allowed", r.Method)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&grafanaMsg); err != nil {
+		reqLog.WithError(err).Error("Failed to decode request body")
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	if grafanaMsg.Receiver == "" || grafanaMsg.Alerts == nil {
+		reqLog.Errorln("POST message without properly formatted data - refusing to continue")
+		return
+	}
+	reqLog.Debugf("Unmarshalled JSON: %#v", grafanaMsg)
+	notifySubscribers(grafanaMsg.toWebhookMessage(), "Grafana alert", grafanaIconURL, reqLog)
+}
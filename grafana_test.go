@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func Test_grafanaAlertHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/grafana", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(grafanaAlertHandler).ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, http.StatusMethodNotAllowed, "")
+
+	testFile, err := os.Open("grafanaTest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err = http.NewRequest("POST", "/grafana", testFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(grafanaAlertHandler).ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, http.StatusOK, "")
+}
+
+func Test_grafanaToWebhookMessage(t *testing.T) {
+	data, err := ioutil.ReadFile("grafanaTest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var grafanaMsg grafanaWebhookMessage
+	if err := json.Unmarshal(data, &grafanaMsg); err != nil {
+		t.Fatal(err)
+	}
+	msg := grafanaMsg.toWebhookMessage()
+	assertEqual(t, msg.Receiver, "wakeup", "")
+	assertEqual(t, msg.ExternalURL, "http://grafana:3000/api/alertmanager/grafana", "")
+	assertEqual(t, len(msg.Alerts), 1, "")
+	assertEqual(t, msg.Alerts[0].GeneratorURL, "http://grafana:3000/d/dashboard_uid?viewPanel=1", "")
+	assertEqual(t, msg.Alerts[0].Annotations["summary"], "[ var='B' labels={instance=host1} value=1.52 ]", "")
+	assertEqual(t, msg.CommonLabels["grafana_folder"], "Backend", "")
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// handoffCheckInterval is how often schedules are checked for a shift change
+const handoffCheckInterval = time.Minute
+
+// handoffReportWindow is how long after a shift change the handoff report is still sent,
+// so botanist does not report old handoffs after being down for a while
+const handoffReportWindow = time.Hour
+
+// handoffSilence is a silence expiring during the next shift
+type handoffSilence struct {
+	Alertmanager string
+	Matchers     string
+	EndsAt       time.Time
+}
+
+// handoffReport summarizes a shift for the outgoing and incoming on-call participants
+type handoffReport struct {
+	Schedule           string
+	Outgoing, Incoming onCallShift
+	// Alerts that fired during the outgoing shift
+	Fired []*alertRecord
+	// Alerts still firing at the handoff
+	Firing []*alertRecord
+	// Firing alerts somebody acknowledged
+	Acknowledged []*alertRecord
+	Silences     []handoffSilence
+}
+
+// scheduleReceivers returns the alert groups routed to a schedule
+func scheduleReceivers(schedule string) (map[string]bool, error) {
+	subs, err := subscriptions.All()
+	if err != nil {
+		return nil, err
+	}
+	receivers := make(map[string]bool)
+	for _, sub := range subs {
+		if sub.Backend == onCallBackend && strings.EqualFold(sub.MessagePath, schedule) {
+			receivers[sub.AlertGroup] = true
+		}
+	}
+	return receivers, nil
+}
+
+func (record *alertRecord) acknowledged() (alertAction, bool) {
+	for _, action := range record.Actions {
+		if action.Action == "acknowledged" {
+			return action, true
+		}
+	}
+	return alertAction{}, false
+}
+
+// buildHandoffReport collects the alerts of the receivers routed to the schedule, or all alerts if none are
+func buildHandoffReport(schedule OnCallSchedule, outgoing, incoming onCallShift, silences []handoffSilence) (handoffReport, error) {
+	report := handoffReport{Schedule: schedule.Name, Outgoing: outgoing, Incoming: incoming}
+	receivers, err := scheduleReceivers(schedule.Name)
+	if err != nil {
+		return report, err
+	}
+	records, err := queryHistory(nil, outgoing.Start)
+	if err != nil {
+		return report, err
+	}
+	for _, record := range records {
+		if len(receivers) > 0 && !receivers[record.Receiver] {
+			continue
+		}
+		if !record.StartsAt.Before(outgoing.Start) && record.StartsAt.Before(incoming.Start) {
+			report.Fired = append(report.Fired, record)
+		}
+		if record.firing() {
+			report.Firing = append(report.Firing, record)
+			if _, ok := record.acknowledged(); ok {
+				report.Acknowledged = append(report.Acknowledged, record)
+			}
+		}
+	}
+	for _, silence := range silences {
+		if !silence.EndsAt.Before(incoming.Start) && silence.EndsAt.Before(incoming.End) {
+			report.Silences = append(report.Silences, silence)
+		}
+	}
+	return report, nil
+}
+
+func formatAlertRecord(record *alertRecord) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s", record.Labels["alertname"], record.Labels["instance"]))
+}
+
+func (report handoffReport) String() string {
+	var text strings.Builder
+	layout := "Mon Jan 2 15:04 MST"
+	fmt.Fprintf(&text, "Handoff of %s from %s to %s\n", report.Schedule, report.Outgoing.Name, report.Incoming.Name)
+	fmt.Fprintf(&text, "Next shift: %s until %s\n", report.Incoming.Start.Format(layout), report.Incoming.End.Format(layout))
+
+	fmt.Fprintf(&text, "\nFired during the last shift: %d\n", len(report.Fired))
+	for _, record := range report.Fired {
+		fmt.Fprintf(&text, "- %s at %s", formatAlertRecord(record), record.StartsAt.Format(layout))
+		if !record.firing() {
+			fmt.Fprintf(&text, ", resolved after %s", record.duration(record.EndsAt).Round(time.Minute))
+		}
+		text.WriteString("\n")
+	}
+	fmt.Fprintf(&text, "\nStill firing: %d\n", len(report.Firing))
+	for _, record := range report.Firing {
+		fmt.Fprintf(&text, "- %s since %s\n", formatAlertRecord(record), record.StartsAt.Format(layout))
+	}
+	fmt.Fprintf(&text, "\nOpen acknowledgments: %d\n", len(report.Acknowledged))
+	for _, record := range report.Acknowledged {
+		action, _ := record.acknowledged()
+		fmt.Fprintf(&text, "- %s acknowledged by %s at %s\n", formatAlertRecord(record), action.By, action.At.Format(layout))
+	}
+	fmt.Fprintf(&text, "\nSilences expiring during the next shift: %d\n", len(report.Silences))
+	for _, silence := range report.Silences {
+		fmt.Fprintf(&text, "- %s on %s ends %s\n", silence.Matchers, silence.Alertmanager, silence.EndsAt.Format(layout))
+	}
+	return text.String()
+}
+
+// activeSilences returns the active silences of all configured Alertmanagers
+func activeSilences(ctx context.Context) []handoffSilence {
+	var silences []handoffSilence
+	for _, amConfig := range currentConfig().Alertmanagers {
+		amSilences, err := amConfig.getSilences(ctx, nil)
+		if err != nil {
+			log.Warnf("Could not fetch silences of %s for handoff report: %s", amConfig.displayName(), err)
+			continue
+		}
+		for _, silence := range amSilences {
+			if silence.Status == nil || silence.Status.State != "active" {
+				continue
+			}
+			var matchers []string
+			for _, matcher := range silence.Matchers {
+				matchers = append(matchers, matcher.Name+"="+matcher.Value)
+			}
+			silences = append(silences, handoffSilence{Alertmanager: amConfig.displayName(), Matchers: strings.Join(matchers, ", "), EndsAt: silence.EndsAt})
+		}
+	}
+	return silences
+}
+
+func handoffKey(schedule string) []byte {
+	return []byte("handoff/" + schedule)
+}
+
+// nextHandoff returns the shifts of a schedule that changed since the last handoff report.
+// ok is false if there is nothing to report, e.g. because nobody joined a schedule created via chat yet
+func nextHandoff(schedule OnCallSchedule, now time.Time) (outgoing, incoming onCallShift, ok bool, err error) {
+	if len(schedule.Participants) == 0 {
+		return
+	}
+	rotation, err := schedule.rotationShift(now)
+	if err != nil {
+		return
+	}
+	var reported time.Time
+	err = stateDB.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(metaBucket).Get(handoffKey(schedule.Name)); data != nil {
+			return reported.UnmarshalText(data)
+		}
+		return nil
+	})
+	if err != nil || reported.Equal(rotation.Start) || now.Sub(rotation.Start) > handoffReportWindow {
+		return
+	}
+	previous, err := schedule.rotationShift(rotation.Start.Add(-time.Second))
+	if err != nil {
+		return
+	}
+	// Report to whoever is on call around the handoff, including people covering with an override
+	outgoingOnCall, err := schedule.shiftAt(rotation.Start.Add(-time.Second))
+	if err != nil {
+		return
+	}
+	incomingOnCall, err := schedule.shiftAt(rotation.Start)
+	if err != nil {
+		return
+	}
+	outgoing = onCallShift{OnCallParticipant: outgoingOnCall.OnCallParticipant, Start: previous.Start, End: previous.End}
+	incoming = onCallShift{OnCallParticipant: incomingOnCall.OnCallParticipant, Start: rotation.Start, End: rotation.End}
+	return outgoing, incoming, true, nil
+}
+
+// sendHandoffReports posts a handoff report to both participants of every schedule that just handed over
+func sendHandoffReports(ctx context.Context, now time.Time) error {
+	schedules, err := allSchedules()
+	if err != nil {
+		return err
+	}
+	var silences []handoffSilence
+	fetchedSilences := false
+	for _, schedule := range schedules {
+		outgoing, incoming, ok, err := nextHandoff(schedule, now)
+		if err != nil {
+			log.Errorf("Could not check schedule %s for a handoff: %s", schedule.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !fetchedSilences {
+			silences, fetchedSilences = activeSilences(ctx), true
+		}
+		report, err := buildHandoffReport(schedule, outgoing, incoming, silences)
+		if err != nil {
+			log.Errorf("Could not build handoff report of %s: %s", schedule.Name, err)
+			continue
+		}
+		log.Infof("Sending handoff report of %s from %s to %s", schedule.Name, outgoing.Name, incoming.Name)
+		if err := queueHandoffReport(schedule, outgoing, incoming, report); err != nil {
+			log.Errorf("Could not queue handoff report of %s, retrying: %s", schedule.Name, err)
+		}
+	}
+	return nil
+}
+
+// queueHandoffReport queues the report for both participants and marks the handoff as reported
+// in one transaction, so a failed attempt is retried at the next check
+func queueHandoffReport(schedule OnCallSchedule, outgoing, incoming onCallShift, report handoffReport) error {
+	message := &genericMessage{ContentText: report.String()}
+	recipients := []OnCallParticipant{outgoing.OnCallParticipant}
+	if incoming.MessagePath != outgoing.MessagePath {
+		recipients = append(recipients, incoming.OnCallParticipant)
+	}
+	err := stateDB.Update(func(tx *bolt.Tx) error {
+		for _, participant := range recipients {
+			if err := queueDelivery(tx, participant.user(), message); err != nil {
+				return fmt.Errorf("queueing for %s: %s", participant.Name, err)
+			}
+		}
+		data, err := incoming.Start.MarshalText()
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(metaBucket).Put(handoffKey(schedule.Name), data)
+	})
+	if err != nil {
+		return err
+	}
+	messageOutbox.wakeWorker()
+	return nil
+}
+
+// startHandoffReports sends handoff reports at every shift change until ctx is cancelled
+func startHandoffReports(ctx context.Context) {
+	runEvery(ctx, handoffCheckInterval, func(now time.Time) {
+		if err := sendHandoffReports(ctx, now); err != nil {
+			log.Errorf("Failed to send handoff reports: %s", err)
+		}
+	})
+}
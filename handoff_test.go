@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_handoffReport(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldSubscriptions := subscriptions
+	defer func() { subscriptions = oldSubscriptions }()
+	subscriptions = newFileSubscriptionStore("")
+
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	schedule := OnCallSchedule{
+		Name:     "sre",
+		TimeZone: "Europe/Berlin",
+		Start:    "2018-12-03",
+		Participants: []OnCallParticipant{
+			{Name: "Jane", MessagePath: "spaces/jane"},
+			{Name: "Joe", MessagePath: "spaces/joe"},
+		},
+	}
+	if err := schedule.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The test alert fires on 2018-12-06, during Jane's first shift
+	msg := loadTestWebhook(t)
+	if err := recordWebhook(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordAlertAction(msg.Receiver, msg.CommonLabels, "acknowledged", "Jane"); err != nil {
+		t.Fatal(err)
+	}
+
+	handoff := time.Date(2018, 12, 10, 9, 0, 0, 0, berlin)
+	_, _, ok, err := nextHandoff(schedule, handoff.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ok, false, "shifts that started long ago are not reported")
+	outgoing, incoming, ok, err := nextHandoff(schedule, handoff.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ok, true, "shift changed")
+	assertEqual(t, outgoing.Name, "Jane", "")
+	assertEqual(t, incoming.Name, "Joe", "")
+	assertEqual(t, incoming.Start.Equal(handoff), true, incoming.Start.String())
+	_, _, ok, err = nextHandoff(schedule, handoff.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ok, true, "handoff is reported again until its report is queued")
+	if err := queueHandoffReport(schedule, outgoing, incoming, handoffReport{}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 2, "")
+	_, _, ok, err = nextHandoff(schedule, handoff.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ok, false, "handoff is only reported once")
+	_, _, ok, err = nextHandoff(OnCallSchedule{Name: "empty", Start: "2018-12-03"}, handoff.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, ok, false, "schedules without participants are skipped")
+
+	silences := []handoffSilence{
+		{Alertmanager: "am", Matchers: "alertname=Expiring", EndsAt: handoff.AddDate(0, 0, 2)},
+		{Alertmanager: "am", Matchers: "alertname=Later", EndsAt: handoff.AddDate(0, 1, 0)},
+	}
+	report, err := buildHandoffReport(schedule, outgoing, incoming, silences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(report.Fired), 1, "")
+	assertEqual(t, len(report.Firing), 1, "")
+	assertEqual(t, len(report.Acknowledged), 1, "")
+	assertEqual(t, len(report.Silences), 1, "")
+	assertEqual(t, report.Silences[0].Matchers, "alertname=Expiring", "")
+	text := report.String()
+	assertEqual(t, strings.Contains(text, "Handoff of sre from Jane to Joe"), true, text)
+	assertEqual(t, strings.Contains(text, "WakeupTest host1 acknowledged by Jane"), true, text)
+
+	// Alerts of receivers not routed to the schedule are left out
+	if err := subscriptions.Subscribe(Subscription{AlertGroup: "other", Backend: onCallBackend, Userinfo: Userinfo{MessagePath: "sre"}}); err != nil {
+		t.Fatal(err)
+	}
+	report, err = buildHandoffReport(schedule, outgoing, incoming, silences)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(report.Fired), 0, "")
+	assertEqual(t, len(report.Firing), 0, "")
+}
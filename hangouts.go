@@ -6,16 +6,22 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/prometheus/alertmanager/template"
+	bolt "go.etcd.io/bbolt"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/chat/v1"
 	"google.golang.org/api/option"
 )
 
+// dmSpaceBucket maps hangouts users to their direct message space with botanist
+var dmSpaceBucket = []byte("dmSpaces")
+
 // HangoutsConfig specific configuration for Hangouts
 // This stores the connection properties and the
 // alertGroups to User mapping in Hangouts
@@ -27,86 +33,182 @@ type HangoutsConfig struct {
 	// Pub/Sub subscription name configured for Hangouts Chat
 	PsSubscription string `yaml:"psSubscripton,omitempty"`
 
-	// Persistent config about who to "annoy" about Prometheus alerts
+	// Subscriptions kept in the config file by earlier versions of botanist.
+	// Only read to migrate them into the subscription store
 	PromAlertSubscribers map[string]map[string]HangoutsUser `yaml:"promAlertSubscribers,omitempty"`
 }
 
 var (
-	ctx         context.Context
+	// ctx is used for API calls, which should complete even while botanist shuts down
+	ctx         = context.Background()
 	cursorTimer = time.Time{}
-	sms         *chat.SpacesMessagesService
+	// sms is the chat API client. It is only read once smsReady is closed, see messagesService
+	sms      *chat.SpacesMessagesService
+	smsReady = make(chan struct{})
+	smsOnce  sync.Once
+	// set while we are receiving messages from Pub/Sub
+	hangoutsReceiving int32
 )
 
-func initHangouts() {
+// setMessagesService publishes the chat API client to the outbox workers and other senders
+func setMessagesService(service *chat.SpacesMessagesService) {
+	smsOnce.Do(func() {
+		sms = service
+		close(smsReady)
+	})
+}
+
+// messagesService returns the chat API client, or an error while it is not set up yet
+func messagesService() (*chat.SpacesMessagesService, error) {
+	select {
+	case <-smsReady:
+		return sms, nil
+	default:
+		return nil, fmt.Errorf("the Hangouts Chat API client is not set up yet")
+	}
+}
+
+// runHangouts receives chat events from Pub/Sub until receiveCtx is cancelled.
+// Events that are being handled when it is cancelled are finished before it returns
+func runHangouts(receiveCtx context.Context) error {
 	log.Infoln("Initializing Hangouts backend")
+	registerReadinessCheck("hangouts_pubsub", func() error {
+		if atomic.LoadInt32(&hangoutsReceiving) == 0 {
+			return fmt.Errorf("not receiving Pub/Sub messages")
+		}
+		return nil
+	})
 
 	// This seems like a hack, but some of the oauth libraries expect an environment variable
 	// if you use the JSON file, as opposed to being able to specify the path
 	// as part of client creation.
-	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", botanistConfig.Hangouts.CredentialsFile)
-	ctx = context.Background()
-
-	client, err := pubsub.NewClient(ctx, botanistConfig.Hangouts.Project, option.WithCredentialsFile(botanistConfig.Hangouts.CredentialsFile))
+	hangoutsConfig := currentConfig().Hangouts
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", hangoutsConfig.CredentialsFile)
 
+	client, err := pubsub.NewClient(ctx, hangoutsConfig.Project, option.WithCredentialsFile(hangoutsConfig.CredentialsFile))
 	if err != nil {
-		log.Fatalf("error creating newclient: %v.\n", err)
+		return fmt.Errorf("error creating Pub/Sub client: %v", err)
 	}
+	defer client.Close()
 
-	sub := client.Subscription(botanistConfig.Hangouts.PsSubscription)
+	sub := client.Subscription(hangoutsConfig.PsSubscription)
 
 	httpClient, err := google.DefaultClient(oauth2.NoContext, "https://www.googleapis.com/auth/chat.bot")
 	if err != nil {
-		log.Fatalf("Error creating httpClient: %v.\n", err)
+		return fmt.Errorf("error creating httpClient: %v", err)
 	}
 
 	chatService, err := chat.New(httpClient)
 	if err != nil {
-		log.Fatalf("Error creating chatService: %v.\n", err)
+		return fmt.Errorf("error creating chatService: %v", err)
 	}
 
-	sms = chat.NewSpacesMessagesService(chatService)
+	messages := chat.NewSpacesMessagesService(chatService)
+	setMessagesService(messages)
 
-	cctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+	registerReadinessCheck("hangouts_chat_api", cachedCheck(time.Minute, func() error {
+		_, err := chatService.Spaces.List().PageSize(1).Do()
+		return err
+	}))
 
-	ok, err := sub.Exists(ctx)
+	ok, err := sub.Exists(receiveCtx)
 	if err != nil {
-		log.Fatalf("Error checking if subscription exists. Err: %v.", err)
+		return fmt.Errorf("error checking if subscription exists: %v", err)
 	}
 	if !ok {
-		log.Fatalln("Checked if subscription exists. It doesn't.")
+		return fmt.Errorf("subscription %s does not exist", hangoutsConfig.PsSubscription)
 	}
 
-	var incomingMessage *chat.DeprecatedEvent
-
-	err = sub.Receive(cctx, func(ctx context.Context, msg *pubsub.Message) {
+	atomic.StoreInt32(&hangoutsReceiving, 1)
+	defer atomic.StoreInt32(&hangoutsReceiving, 0)
+	err = sub.Receive(receiveCtx, func(_ context.Context, msg *pubsub.Message) {
 		log.Debugf("Received Message %s.\n", string(msg.Data))
 		msg.Ack()
+		pubsubReceiveLatency.Observe(time.Since(msg.PublishTime).Seconds())
 
+		var incomingMessage *chat.DeprecatedEvent
 		err := json.Unmarshal(msg.Data, &incomingMessage)
 		if err != nil {
-			log.Fatalf("Unable to decode Chat Message JSON: %v.\n", err)
+			log.Errorf("Unable to decode Chat Message JSON: %v.\n", err)
+			return
+		}
+
+		var eventUser hangoutsEventUser
+		if err := json.Unmarshal(msg.Data, &eventUser); err != nil {
+			log.Warnf("Unable to decode user of Chat Message: %v.\n", err)
 		}
 
-		responseMessage := reactToMessage(incomingMessage)
+		responseMessage := reactToMessage(incomingMessage, eventUser.User.Email)
 		if responseMessage == nil {
 			return
 		}
 
 		log.Debugf("My Space: %#v.\n", incomingMessage.Space)
-		response, err := sms.Create(incomingMessage.Space.Name, responseMessage).Do()
+		response, err := messages.Create(incomingMessage.Space.Name, responseMessage).Do()
+		countMessage("hangouts", err)
 		if err != nil {
 			log.Warnf("There was an error sending a response back to Hangouts Chat: %v.\n", err)
 		}
 		log.Debugf("Hangouts Response: %+v.\n", response)
 	})
 	if err != nil {
-		log.Warnf("Error when receiving pubsub message: %v.\n", err)
+		return fmt.Errorf("error when receiving Pub/Sub messages: %v", err)
+	}
+	return nil
+}
+
+// hangoutsEventUser carries the fields of an event's user the chat library does not decode
+type hangoutsEventUser struct {
+	User struct {
+		Email string `json:"email"`
+	} `json:"user"`
+}
+
+// eventSender returns the user who caused an event
+func eventSender(message *chat.DeprecatedEvent, email string) HangoutsUser {
+	return HangoutsUser{
+		&Userinfo{
+			MessagePath:  message.Space.Name,
+			Username:     message.User.Name,
+			FriendlyName: message.User.DisplayName,
+			Email:        email,
+		},
+	}
+}
+
+// rememberDMSpace stores the direct message space of a user who wrote to botanist directly
+func rememberDMSpace(username, space string) error {
+	if stateDB == nil || username == "" {
+		return nil
 	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dmSpaceBucket)
+		if string(bucket.Get([]byte(username))) == space {
+			return nil
+		}
+		return bucket.Put([]byte(username), []byte(space))
+	})
+}
 
+// dmSpace returns the direct message space of a user, empty if they never wrote to botanist directly
+func dmSpace(username string) (string, error) {
+	var space string
+	if stateDB == nil {
+		return space, nil
+	}
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		space = string(tx.Bucket(dmSpaceBucket).Get([]byte(username)))
+		return nil
+	})
+	return space, err
 }
 
-func reactToMessage(message *chat.DeprecatedEvent) *chat.Message {
+func reactToMessage(message *chat.DeprecatedEvent, email string) *chat.Message {
+	if message.Space != nil && message.Space.Type == "DM" && message.User != nil {
+		if err := rememberDMSpace(message.User.Name, message.Space.Name); err != nil {
+			log.Warnf("Unable to remember the direct message space of %s: %s", message.User.DisplayName, err)
+		}
+	}
 	switch message.Type {
 	case "ADDED_TO_SPACE":
 		responseMessage := &chat.Message{Text: "Thanks for adding me"}
@@ -116,15 +218,8 @@ func reactToMessage(message *chat.DeprecatedEvent) *chat.Message {
 		}
 		return responseMessage
 	case "MESSAGE":
-		sender := HangoutsUser{
-			&Userinfo{
-				MessagePath:  message.Space.Name,
-				Username:     message.User.Name,
-				FriendlyName: message.User.DisplayName,
-			},
-		}
 		genericMsg := genericMessage{
-			Sender:      sender,
+			Sender:      eventSender(message, email),
 			ContentText: strings.TrimSpace(message.Message.ArgumentText),
 			Thread:      message.Message.Thread.Name,
 			MessagePath: message.Message.Space.Name,
@@ -133,7 +228,7 @@ func reactToMessage(message *chat.DeprecatedEvent) *chat.Message {
 		hangoutsResponse, _ := genericToHangoutsMessage(response)
 		return hangoutsResponse
 	case "CARD_CLICKED":
-		return handleClick(message)
+		return handleClick(message, eventSender(message, email))
 	case "REMOVED_FROM_SPACE":
 		// We should clean up the User's subscriptions here
 		return nil
@@ -142,31 +237,67 @@ func reactToMessage(message *chat.DeprecatedEvent) *chat.Message {
 	return nil
 }
 
-func handleClick(message *chat.DeprecatedEvent) *chat.Message {
+func handleClick(message *chat.DeprecatedEvent, clicker HangoutsUser) *chat.Message {
 	if isOutdatedClick(message.EventTime) {
 		return nil
 	}
 
 	log.Infof("User %s instructed me to execute %s", message.User.DisplayName, message.Action.ActionMethodName)
-	var alertMgrAddress string
+	buttonClicks.WithLabelValues(message.Action.ActionMethodName).Inc()
+	callback := message.Action.ActionMethodName
+	if err := authorize(clicker.Userinfo, callback, requiredRole(callbackRoles, callback)); err != nil {
+		return &chat.Message{Text: err.Error()}
+	}
+	var alertMgrAddress, receiver, key string
 	commonLabels := make(template.KV)
 	for _, param := range message.Action.Parameters {
 		switch param.Key {
-		case "labels":
-			alertMgrAddress = param.Value
 		case "alertMgrAddress":
+			alertMgrAddress = param.Value
+		case "receiver":
+			receiver = param.Value
+		case "key":
+			key = param.Value
+		case "labels":
 			err := json.Unmarshal([]byte(param.Value), &commonLabels)
 			if err != nil {
-				log.Fatalf("Issues unmarshaling commonLabels: %s", err)
+				log.Errorf("Issues unmarshaling commonLabels: %s", err)
+				return &chat.Message{Text: "I could not understand which alert you mean"}
 			}
 		}
 	}
-	silenceWithLabels(commonLabels, message.User.DisplayName, alertMgrAddress)
+	// Cards sent by earlier versions do not carry the key of their alert group
+	if key == "" {
+		key = alertGroupKey(receiver, commonLabels)
+	}
+	if callback == ackCallback {
+		return acknowledgeClick(message, clicker, receiver, key, commonLabels)
+	}
+
+	silenceID, err := silenceWithLabels(commonLabels, message.User.DisplayName, alertMgrAddress)
+	if err != nil {
+		log.Errorf("Issues when adding silence in alertmanager: %s", err)
+		return &chat.Message{Text: fmt.Sprintf("There was an error silencing this alert: \n %s", err)}
+	}
+	auditUser(clicker, auditSilenceCreated, silenceID, map[string]string{
+		"alertmanager": getAlertmanagerConfig(alertMgrAddress).displayName(),
+		"matchers":     formatAuditParameters(commonLabels),
+		"duration":     "1h",
+	})
+	if err := recordAlertAction(receiver, commonLabels, "silenced", message.User.DisplayName); err != nil {
+		log.Errorf("Issues when storing silence in alert history: %s", err)
+	}
+	if err := stopEscalation(key, "silenced by "+message.User.DisplayName); err != nil {
+		log.Errorf("Issues when stopping escalation: %s", err)
+	}
 
 	response := message.Message
 	response.ActionResponse = &chat.ActionResponse{Type: "UPDATE_MESSAGE"}
 	response.Cards[0].Header.Title = "SILENCED!"
-	_, err := sms.Update(message.Message.Name, response).UpdateMask("cards").Do()
+	messages, err := messagesService()
+	if err == nil {
+		_, err = messages.Update(message.Message.Name, response).UpdateMask("cards").Do()
+	}
 	if err != nil {
 		return &chat.Message{Text: fmt.Sprintf("There was an error silencing this alert: \n %s", err)}
 	}
@@ -176,6 +307,53 @@ func handleClick(message *chat.DeprecatedEvent) *chat.Message {
 	return &chat.Message{Text: fmt.Sprintf("%s silenced an alarm for an hour", message.User.DisplayName)}
 }
 
+// acknowledgeClick records who is working on an alert and marks every copy of its card
+func acknowledgeClick(message *chat.DeprecatedEvent, clicker HangoutsUser, receiver, key string, commonLabels template.KV) *chat.Message {
+	cards, err := acknowledgeAlert(clicker, receiver, key, commonLabels)
+	if err != nil {
+		log.Errorf("Issues when acknowledging alert: %s", err)
+		return &chat.Message{Text: fmt.Sprintf("There was an error acknowledging this alert: \n %s", err)}
+	}
+
+	title := "Acked by " + message.User.DisplayName
+	if err := updateCardTitle(message.Message, title); err != nil {
+		log.Errorf("Could not update card %s: %s", message.Message.Name, err)
+	}
+	for _, card := range cards {
+		if card.Backend != clicker.getBackend() || card.Name == message.Message.Name {
+			continue
+		}
+		messages, err := messagesService()
+		var sent *chat.Message
+		if err == nil {
+			sent, err = messages.Get(card.Name).Do()
+		}
+		if err == nil {
+			err = updateCardTitle(sent, title)
+		}
+		if err != nil {
+			log.Errorf("Could not update card %s: %s", card.Name, err)
+		}
+	}
+
+	updateCursorTime(message.EventTime)
+	return &chat.Message{Text: fmt.Sprintf("%s acknowledged the alert", message.User.DisplayName)}
+}
+
+// updateCardTitle replaces the title of a card message
+func updateCardTitle(msg *chat.Message, title string) error {
+	if len(msg.Cards) == 0 || msg.Cards[0].Header == nil {
+		return fmt.Errorf("message has no card header")
+	}
+	msg.Cards[0].Header.Title = title
+	messages, err := messagesService()
+	if err != nil {
+		return err
+	}
+	_, err = messages.Update(msg.Name, msg).UpdateMask("cards").Do()
+	return err
+}
+
 func isOutdatedClick(eventTime string) bool {
 	messageTimestamp, err := time.Parse(time.RFC3339Nano, eventTime)
 	if err != nil {
@@ -198,9 +376,13 @@ func updateCursorTime(eventTime string) {
 }
 
 func genericToHangoutsMessage(msg *genericMessage) (*chat.Message, error) {
+	var thread *chat.Thread
+	if msg.Thread != "" {
+		thread = &chat.Thread{Name: msg.Thread}
+	}
 	if len(msg.Buttons) == 0 {
 		// When there are no buttons, assume it is a regular text message
-		return &chat.Message{Text: msg.ContentText}, nil
+		return &chat.Message{Text: msg.ContentText, Thread: thread}, nil
 	}
 
 	var sections []*chat.Section
@@ -247,37 +429,34 @@ func genericToHangoutsMessage(msg *genericMessage) (*chat.Message, error) {
 				ImageUrl: msg.HeaderPictureURL,
 			},
 			Sections: sections,
-		}}}
+		}},
+		Thread: thread,
+	}
 	return hangoutsMessage, nil
 }
 
 // We use a map[User]struct{} here to have a unique list of users
-// that belong to the named group and the special group "all"
+// that belong to the named group and the special group "all".
+// Schedules subscribed to the group are notified through whoever is on call
 func getHangoutsUsersForAlertGroup(group string) map[User]struct{} {
-	if len(botanistConfig.Hangouts.PromAlertSubscribers) == 0 {
-		// no alertGroups defined yet
-		return make(map[User]struct{})
-	}
-	alertGroup := botanistConfig.Hangouts.PromAlertSubscribers[group]
-	allGroup := botanistConfig.Hangouts.PromAlertSubscribers["all"]
-	if len(alertGroup) == 0 {
-		// no receivers defined for our set alertGroup
-		if len(allGroup) == 0 {
-			// no receivers defined for all alerts
-			return make(map[User]struct{})
-		}
-		// init empty map so that we can handle it easier below
-		alertGroup = make(map[string]HangoutsUser)
-	}
 	userList := make(map[User]struct{})
-
-	for _, user := range alertGroup {
-		userList[user] = struct{}{}
-	}
-	for _, user := range allGroup {
-		userList[user] = struct{}{}
+	for _, alertGroup := range []string{group, "all"} {
+		subs, err := subscriptions.Subscribers(alertGroup)
+		if err != nil {
+			log.Errorf("Could not read subscribers of alert group %s: %s", alertGroup, err)
+			continue
+		}
+		for _, sub := range subs {
+			switch sub.Backend {
+			case "hangouts":
+				info := sub.Userinfo
+				userList[HangoutsUser{&info}] = struct{}{}
+			case onCallBackend:
+				info := sub.Userinfo
+				userList[OnCallUser{&info}] = struct{}{}
+			}
+		}
 	}
-
 	return userList
 }
 
@@ -287,32 +466,36 @@ func (hoUser HangoutsUser) sendMessage(msg *genericMessage) error {
 		return err
 	}
 
-	_, err = sms.Create(hoUser.MessagePath, hangoutsMessage).Do()
+	messages, err := messagesService()
+	if err != nil {
+		return err
+	}
+	created, err := messages.Create(hoUser.MessagePath, hangoutsMessage).Do()
+	countMessage("hangouts", err)
+	if err == nil && msg.AlertKey != "" {
+		var thread string
+		if created.Thread != nil {
+			thread = created.Thread.Name
+		}
+		if err := recordSentCard(msg.AlertKey, hoUser.getBackend(), created.Name, thread); err != nil {
+			log.Errorf("Could not remember card %s: %s", created.Name, err)
+		}
+	}
 	return err
 }
 
 func (hoUser HangoutsUser) addToAlertGroup(group string) error {
-	if len(botanistConfig.Hangouts.PromAlertSubscribers) == 0 {
-		botanistConfig.Hangouts.PromAlertSubscribers = make(map[string]map[string]HangoutsUser)
-	}
-	if len(botanistConfig.Hangouts.PromAlertSubscribers[group]) > 0 {
-		botanistConfig.Hangouts.PromAlertSubscribers[group][hoUser.MessagePath] = hoUser
-	} else {
-		botanistConfig.Hangouts.PromAlertSubscribers[group] = map[string]HangoutsUser{hoUser.MessagePath: hoUser}
-	}
-	return persistConfigChanges()
+	return subscriptions.Subscribe(Subscription{AlertGroup: group, Backend: hoUser.getBackend(), Userinfo: *hoUser.Userinfo})
 }
 
 func (hoUser HangoutsUser) delFromAlertGroup(group string) error {
-	if len(botanistConfig.Hangouts.PromAlertSubscribers) == 0 ||
-		len(botanistConfig.Hangouts.PromAlertSubscribers[group]) == 0 {
-		return nil
-	}
-	delete(botanistConfig.Hangouts.PromAlertSubscribers[group], hoUser.MessagePath)
-	log.Debugf("Current config \n %#v", botanistConfig.Hangouts)
-	return persistConfigChanges()
+	return subscriptions.Unsubscribe(Subscription{AlertGroup: group, Backend: hoUser.getBackend(), Userinfo: *hoUser.Userinfo})
 }
 
 func (hoUser HangoutsUser) getUserinfo() *Userinfo {
 	return hoUser.Userinfo
 }
+
+func (hoUser HangoutsUser) getBackend() string {
+	return "hangouts"
+}
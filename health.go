@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// metaBucket holds bookkeeping data of botanist itself
+var metaBucket = []byte("meta")
+
+var (
+	readinessChecksMu sync.Mutex
+	// readinessChecks return an error while the checked component is not ready
+	readinessChecks = map[string]func() error{
+		"database": checkStateDB,
+	}
+)
+
+// registerReadinessCheck adds a component that /readyz reports on
+func registerReadinessCheck(name string, check func() error) {
+	readinessChecksMu.Lock()
+	defer readinessChecksMu.Unlock()
+	readinessChecks[name] = check
+}
+
+// stateDBCheckInterval limits how often the readiness check writes to the database,
+// as every write transaction syncs it to disk
+const stateDBCheckInterval = 5 * time.Second
+
+// checkStateDBWritable makes sure we can still write to the database
+var checkStateDBWritable = cachedCheck(stateDBCheckInterval, func() error {
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put([]byte("lastReadinessCheck"), []byte(time.Now().Format(time.RFC3339)))
+	})
+})
+
+// checkStateDB makes sure the database is open and writable
+func checkStateDB() error {
+	if stateDB == nil {
+		return fmt.Errorf("database not opened")
+	}
+	return checkStateDBWritable()
+}
+
+// cachedCheck only runs the check if its last result is older than maxAge.
+// Limits how often expensive checks run, e.g. calls to remote APIs or database writes
+func cachedCheck(maxAge time.Duration, check func() error) func() error {
+	var (
+		mu        sync.Mutex
+		lastRun   time.Time
+		lastError error
+	)
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(lastRun) > maxAge {
+			lastError = check()
+			lastRun = time.Now()
+		}
+		return lastError
+	}
+}
+
+// healthzHandler answers as long as botanist is able to serve HTTP
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// readinessResult is the outcome of a single readiness check
+type readinessResult struct {
+	Name string
+	Err  error
+}
+
+// checkReadiness runs all readiness checks ordered by name
+func checkReadiness() []readinessResult {
+	readinessChecksMu.Lock()
+	var names []string
+	checks := make(map[string]func() error, len(readinessChecks))
+	for name, check := range readinessChecks {
+		names = append(names, name)
+		checks[name] = check
+	}
+	readinessChecksMu.Unlock()
+	sort.Strings(names)
+
+	results := make([]readinessResult, 0, len(names))
+	for _, name := range names {
+		results = append(results, readinessResult{Name: name, Err: checks[name]()})
+	}
+	return results
+}
+
+// readyzHandler reports whether every backend is connected and the database is writable
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	var report strings.Builder
+	ready := true
+	for _, result := range checkReadiness() {
+		if result.Err != nil {
+			ready = false
+			fmt.Fprintf(&report, "%s: %s\n", result.Name, result.Err)
+			continue
+		}
+		fmt.Fprintf(&report, "%s: ok\n", result.Name)
+	}
+	if !ready {
+		log.WithField("remote_addr", r.RemoteAddr).Warnf("Not ready:\n%s", report.String())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	fmt.Fprint(w, report.String())
+}
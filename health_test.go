@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_readyzHandler(t *testing.T) {
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a database we are not ready
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(readyzHandler).ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, http.StatusServiceUnavailable, "")
+
+	defer openTestStateDB(t)()
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(readyzHandler).ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, http.StatusOK, "")
+
+	// Frequent probes do not write to the database every time
+	lastCheck := func() string {
+		var value string
+		stateDB.View(func(tx *bolt.Tx) error {
+			value = string(tx.Bucket(metaBucket).Get([]byte("lastReadinessCheck")))
+			return nil
+		})
+		return value
+	}
+	stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(metaBucket).Put([]byte("lastReadinessCheck"), []byte("before"))
+	})
+	assertEqual(t, checkStateDB(), nil, "")
+	assertEqual(t, lastCheck(), "before", "")
+
+	defer delete(readinessChecks, "test_backend")
+	registerReadinessCheck("test_backend", func() error { return fmt.Errorf("disconnected") })
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(readyzHandler).ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, http.StatusServiceUnavailable, "")
+	assertEqual(t, rr.Body.String(), "database: ok\ntest_backend: disconnected\n", "")
+}
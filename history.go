@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+	"github.com/sbstjn/allot"
+	bolt "go.etcd.io/bbolt"
+)
+
+// one alertRecord per alert occurrence
+var alertBucket = []byte("alerts")
+
+// obsoleteBuckets were used by earlier versions and are dropped when the database is opened
+var obsoleteBuckets = [][]byte{[]byte("webhooks")}
+
+const defaultHistoryPeriod = 30 * 24 * time.Hour
+
+// defaultHistoryRetention is how long alerts are kept after they were last updated
+const defaultHistoryRetention = 90 * 24 * time.Hour
+
+// historyPruneInterval is how often old alerts are removed from the history
+const historyPruneInterval = time.Hour
+
+// alertRecord is one occurrence of an alert - from firing until it resolved
+type alertRecord struct {
+	Fingerprint  string            `json:"fingerprint"`
+	Receiver     string            `json:"receiver"`
+	Alertmanager string            `json:"alertmanager"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	Status       string            `json:"status"`
+	StartsAt     time.Time         `json:"startsAt"`
+	// EndsAt is zero while the alert is still firing
+	EndsAt time.Time `json:"endsAt,omitempty"`
+	// UpdatedAt is when botanist last received a webhook about the alert
+	UpdatedAt   time.Time         `json:"updatedAt,omitempty"`
+	Transitions []alertTransition `json:"transitions"`
+	Actions     []alertAction     `json:"actions,omitempty"`
+}
+
+// alertTransition records a status change of an alert
+type alertTransition struct {
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
+// alertAction records something a user did about an alert
+type alertAction struct {
+	Action string    `json:"action"`
+	By     string    `json:"by"`
+	At     time.Time `json:"at"`
+}
+
+func alertFingerprint(labels template.KV) string {
+	labelSet := make(model.LabelSet, len(labels))
+	for key, value := range labels {
+		labelSet[model.LabelName(key)] = model.LabelValue(value)
+	}
+	return labelSet.Fingerprint().String()
+}
+
+// alertGroupKey identifies the alerts of a receiver sharing the common labels
+func alertGroupKey(receiver string, commonLabels map[string]string) string {
+	return receiver + "/" + alertFingerprint(commonLabels)
+}
+
+// key sorts all occurrences of an alert by their start time
+func (record *alertRecord) key() []byte {
+	return []byte(fmt.Sprintf("%s/%020d", record.Fingerprint, record.StartsAt.UnixNano()))
+}
+
+func (record *alertRecord) firing() bool {
+	return record.EndsAt.IsZero()
+}
+
+// duration of the occurrence - up to now if it is still firing
+func (record *alertRecord) duration(now time.Time) time.Duration {
+	if record.firing() {
+		return now.Sub(record.StartsAt)
+	}
+	return record.EndsAt.Sub(record.StartsAt)
+}
+
+// matches returns true if the alert carries all of the given labels
+func (record *alertRecord) matches(matchers map[string]string) bool {
+	for key, value := range matchers {
+		if record.Labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (record *alertRecord) addAction(action, by string, at time.Time) {
+	record.Actions = append(record.Actions, alertAction{Action: action, By: by, At: at})
+}
+
+// recordWebhook updates the alert occurrences the webhook mentions
+func recordWebhook(msg *notify.WebhookMessage) error {
+	if stateDB == nil {
+		return nil
+	}
+	now := time.Now()
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		alerts := tx.Bucket(alertBucket)
+		for _, alert := range msg.Alerts {
+			record := &alertRecord{
+				Fingerprint: alertFingerprint(alert.Labels),
+				StartsAt:    alert.StartsAt,
+			}
+			if data := alerts.Get(record.key()); data != nil {
+				if err := json.Unmarshal(data, record); err != nil {
+					return err
+				}
+			}
+			record.Receiver = msg.Receiver
+			record.Alertmanager = getAlertmanagerConfig(msg.ExternalURL).displayName()
+			record.Labels = alert.Labels
+			record.Annotations = alert.Annotations
+			if record.Status != alert.Status {
+				record.Transitions = append(record.Transitions, alertTransition{Status: alert.Status, At: now})
+			}
+			record.Status = alert.Status
+			record.UpdatedAt = now
+			if alert.Status == string(model.AlertResolved) {
+				record.EndsAt = alert.EndsAt
+			}
+			if err := putAlertRecord(alerts, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lastFiringStart returns when the still firing occurrence of an alert started
+func lastFiringStart(fingerprint string) (time.Time, bool) {
+	var startsAt time.Time
+	if stateDB == nil {
+		return startsAt, false
+	}
+	prefix := []byte(fingerprint + "/")
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(alertBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			record := &alertRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			if record.firing() {
+				startsAt = record.StartsAt
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Failed to read alert history: %s", err)
+	}
+	return startsAt, !startsAt.IsZero()
+}
+
+// lastUpdate is when the occurrence last changed. Records of older versions lack UpdatedAt
+func (record *alertRecord) lastUpdate() time.Time {
+	if !record.UpdatedAt.IsZero() {
+		return record.UpdatedAt
+	}
+	if !record.firing() {
+		return record.EndsAt
+	}
+	return record.StartsAt
+}
+
+// pruneHistory removes the alerts that were last updated before the retention period.
+// This includes alerts that never resolved because botanist missed the resolved webhook
+func pruneHistory(now time.Time) error {
+	retention := currentConfig().HistoryRetention
+	if retention == 0 {
+		retention = defaultHistoryRetention
+	}
+	cutoff := now.Add(-retention)
+	var pruned int
+	err := stateDB.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(alertBucket).Cursor()
+		for key, data := cursor.First(); key != nil; {
+			record := &alertRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			if !record.lastUpdate().Before(cutoff) {
+				key, data = cursor.Next()
+				continue
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			pruned++
+			// Delete moves the cursor to the next item
+			key, data = cursor.Seek(key)
+		}
+		return nil
+	})
+	if pruned > 0 {
+		log.Infof("Removed %d alert(s) older than %s from the history", pruned, retention)
+	}
+	return err
+}
+
+// startHistoryPruning prunes the alert history and the sent cards until ctx is cancelled
+func startHistoryPruning(ctx context.Context) {
+	runEvery(ctx, historyPruneInterval, func(now time.Time) {
+		if err := pruneHistory(now); err != nil {
+			log.Errorf("Failed to prune alert history: %s", err)
+		}
+		if err := pruneSentCards(now); err != nil {
+			log.Errorf("Failed to prune sent cards: %s", err)
+		}
+	})
+}
+
+func putAlertRecord(alerts *bolt.Bucket, record *alertRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return alerts.Put(record.key(), data)
+}
+
+// recordAlertAction notes on every firing alert of the receiver matching the labels
+// that a user did something about it, e.g. silenced it
+func recordAlertAction(receiver string, matchers map[string]string, action, by string) error {
+	if stateDB == nil {
+		return nil
+	}
+	now := time.Now()
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		alerts := tx.Bucket(alertBucket)
+		var changed []*alertRecord
+		err := alerts.ForEach(func(_, data []byte) error {
+			record := &alertRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			if record.Receiver == receiver && record.firing() && record.matches(matchers) {
+				record.addAction(action, by, now)
+				changed = append(changed, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// Modifying a bucket while iterating over it is not allowed
+		for _, record := range changed {
+			if err := putAlertRecord(alerts, record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// queryHistory returns all alert occurrences matching the labels
+// that were firing at some point since the given time, oldest first
+func queryHistory(matchers map[string]string, since time.Time) ([]*alertRecord, error) {
+	var records []*alertRecord
+	if stateDB == nil {
+		return records, nil
+	}
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(alertBucket).ForEach(func(_, data []byte) error {
+			record := &alertRecord{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			if !record.matches(matchers) {
+				return nil
+			}
+			if !record.firing() && record.EndsAt.Before(since) {
+				return nil
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].StartsAt.Before(records[j].StartsAt)
+	})
+	return records, err
+}
+
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// parseMatchers understands a plain alertname as well as
+// label matchers like {alertname="Foo",instance="bar"} or alertname=Foo,instance=bar
+func parseMatchers(text string) (map[string]string, error) {
+	text = strings.TrimSpace(text)
+	if !strings.Contains(text, "=") {
+		return map[string]string{"alertname": text}, nil
+	}
+	matchers := make(map[string]string)
+	text = strings.TrimSuffix(strings.TrimPrefix(text, "{"), "}")
+	for _, matcher := range strings.Split(text, ",") {
+		parts := strings.SplitN(matcher, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid matcher %q", matcher)
+		}
+		matchers[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), "\"")
+	}
+	return matchers, nil
+}
+
+// parseSince understands durations like 12h, 7d or 2w and dates like 2019-02-01
+func parseSince(text string, now time.Time) (time.Time, error) {
+	if date, err := time.ParseInLocation("2006-01-02", text, now.Location()); err == nil {
+		return date, nil
+	}
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(text, suffix) {
+			count, err := strconv.Atoi(strings.TrimSuffix(text, suffix))
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid time span %q", text)
+			}
+			return now.Add(-time.Duration(count) * unit), nil
+		}
+	}
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time span %q", text)
+	}
+	return now.Add(-duration), nil
+}
+
+func handleHistory(match allot.MatchInterface, User User) (*genericMessage, error) {
+	query, err := match.String("alert")
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues identifying which alert you mean"}, err
+	}
+	matchers, err := parseMatchers(query)
+	if err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not understand %s: %s", query, err)}, nil
+	}
+	now := time.Now()
+	since := now.Add(-defaultHistoryPeriod)
+	if sinceText, err := match.String("since"); err == nil {
+		since, err = parseSince(sinceText, now)
+		if err != nil {
+			return &genericMessage{ContentText: err.Error()}, nil
+		}
+	}
+
+	records, err := queryHistory(matchers, since)
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues reading the alert history"}, err
+	}
+	return &genericMessage{ContentText: formatHistory(query, records, since, now)}, nil
+}
+
+func formatHistory(query string, records []*alertRecord, since, now time.Time) string {
+	if len(records) == 0 {
+		return fmt.Sprintf("%s has not fired since %s", query, since.Format(time.RFC822))
+	}
+	var text strings.Builder
+	var total time.Duration
+	for _, record := range records {
+		total += record.duration(now)
+	}
+	fmt.Fprintf(&text, "%s fired %d time(s) since %s for %s in total:\n",
+		query, len(records), since.Format(time.RFC822), total.Round(time.Second))
+	for _, record := range records {
+		fmt.Fprintf(&text, "- %s %s at %s", record.Labels["alertname"], record.Labels["instance"], record.StartsAt.Format(time.RFC822))
+		if record.firing() {
+			fmt.Fprintf(&text, ", still firing after %s", record.duration(now).Round(time.Second))
+		} else {
+			fmt.Fprintf(&text, ", resolved after %s", record.duration(now).Round(time.Second))
+		}
+		for _, action := range record.Actions {
+			fmt.Fprintf(&text, ", %s by %s at %s", action.Action, action.By, action.At.Format(time.RFC822))
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+)
+
+func openTestStateDB(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "botanist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := openStateDB(filepath.Join(dir, "botanist.db")); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		stateDB.Close()
+		stateDB = nil
+		os.RemoveAll(dir)
+	}
+}
+
+func loadTestWebhook(t *testing.T) *notify.WebhookMessage {
+	data, err := ioutil.ReadFile("promTest.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg notify.WebhookMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return &msg
+}
+
+func Test_alertHistory(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	msg := loadTestWebhook(t)
+	if err := recordWebhook(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordAlertAction(msg.Receiver, map[string]string{"alertname": "WakeupTest"}, "silenced", "Jane"); err != nil {
+		t.Fatal(err)
+	}
+	since := msg.Alerts[0].StartsAt.Add(-time.Hour)
+	records, err := queryHistory(map[string]string{"alertname": "WakeupTest"}, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(records), 1, "")
+	assertEqual(t, records[0].firing(), true, "")
+	assertEqual(t, len(records[0].Actions), 1, "")
+	assertEqual(t, records[0].Actions[0].By, "Jane", "")
+
+	// Resolving the alert updates the same occurrence
+	msg.Alerts[0].Status = "resolved"
+	if err := recordWebhook(msg); err != nil {
+		t.Fatal(err)
+	}
+	records, err = queryHistory(map[string]string{"instance": "host1"}, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(records), 1, "")
+	assertEqual(t, records[0].firing(), false, "")
+	assertEqual(t, records[0].duration(time.Now()), 8*time.Minute, "")
+	assertEqual(t, len(records[0].Transitions), 2, "")
+
+	records, err = queryHistory(map[string]string{"alertname": "Other"}, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(records), 0, "")
+}
+
+func Test_parseMatchers(t *testing.T) {
+	matchers, err := parseMatchers("WakeupTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, matchers["alertname"], "WakeupTest", "")
+
+	matchers, err = parseMatchers(`{alertname="WakeupTest",instance="host1"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(matchers), 2, "")
+	assertEqual(t, matchers["instance"], "host1", "")
+
+	_, err = parseMatchers("alertname=Foo,=bar")
+	assertEqual(t, err != nil, true, "")
+}
+
+func Test_parseSince(t *testing.T) {
+	now := time.Date(2019, 2, 15, 12, 0, 0, 0, time.UTC)
+	for text, expected := range map[string]time.Time{
+		"12h":        now.Add(-12 * time.Hour),
+		"7d":         now.Add(-7 * 24 * time.Hour),
+		"2w":         now.Add(-14 * 24 * time.Hour),
+		"2019-02-01": time.Date(2019, 2, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		since, err := parseSince(text, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, since, expected, text)
+	}
+	_, err := parseSince("yesterday", now)
+	assertEqual(t, err != nil, true, "")
+}
+
+func Test_pruneHistory(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	msg := loadTestWebhook(t)
+	if err := recordWebhook(msg); err != nil {
+		t.Fatal(err)
+	}
+	since := msg.Alerts[0].StartsAt.Add(-time.Hour)
+	if err := pruneHistory(time.Now().Add(defaultHistoryRetention - time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	records, err := queryHistory(nil, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(records), 1, "recently updated alerts are kept")
+
+	// Also alerts that never resolved are pruned eventually
+	if err := pruneHistory(time.Now().Add(defaultHistoryRetention + time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	records, err = queryHistory(nil, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(records), 0, "old alerts are pruned")
+}
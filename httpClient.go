@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// HTTPClientConfig holds the credentials and TLS settings
+// botanist uses when talking to Alertmanager or Prometheus
+type HTTPClientConfig struct {
+	BasicAuth       *BasicAuth `yaml:"basicAuth,omitempty"`
+	BearerToken     string     `yaml:"bearerToken,omitempty"`
+	BearerTokenFile string     `yaml:"bearerTokenFile,omitempty"`
+	TLSConfig       TLSConfig  `yaml:"tlsConfig,omitempty"`
+}
+
+// BasicAuth credentials for HTTP basic authentication
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"passwordFile,omitempty"`
+}
+
+// TLSConfig configures the TLS side of outgoing HTTP connections
+type TLSConfig struct {
+	// CA certificate to verify the server with
+	CAFile string `yaml:"caFile,omitempty"`
+	// Client certificate and key to authenticate botanist
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+	// ServerName to expect in the server certificate
+	ServerName         string `yaml:"serverName,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify,omitempty"`
+}
+
+// apiClient is a small JSON API client with authentication and TLS
+type apiClient struct {
+	baseURL     *url.URL
+	httpClient  *http.Client
+	basicAuth   *BasicAuth
+	bearerToken string
+}
+
+func newAPIClient(address string, clientConfig HTTPClientConfig) (*apiClient, error) {
+	baseURL, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %s", address, err)
+	}
+	if baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q: scheme and host required", address)
+	}
+
+	tlsConfig, err := newTLSClientConfig(clientConfig.TLSConfig)
+	if err != nil {
+		return nil, err
+	}
+	bearerToken := clientConfig.BearerToken
+	if clientConfig.BearerTokenFile != "" {
+		token, err := ioutil.ReadFile(clientConfig.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read bearer token file %s: %s", clientConfig.BearerTokenFile, err)
+		}
+		bearerToken = strings.TrimSpace(string(token))
+	}
+	basicAuth := clientConfig.BasicAuth
+	if basicAuth != nil && basicAuth.PasswordFile != "" {
+		password, err := ioutil.ReadFile(basicAuth.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read password file %s: %s", basicAuth.PasswordFile, err)
+		}
+		basicAuth = &BasicAuth{Username: basicAuth.Username, Password: strings.TrimSpace(string(password))}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &apiClient{
+		baseURL:     baseURL,
+		httpClient:  &http.Client{Transport: transport, Timeout: 30 * time.Second},
+		basicAuth:   basicAuth,
+		bearerToken: bearerToken,
+	}, nil
+}
+
+func newTLSClientConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read CA file %s: %s", cfg.CAFile, err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = caPool
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %s", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// do sends a request to the API and decodes the JSON answer into result
+func (c *apiClient) do(ctx context.Context, method, apiPath string, query url.Values, body, result interface{}) error {
+	endpoint := *c.baseURL
+	endpoint.Path = path.Join(endpoint.Path, apiPath)
+	endpoint.RawQuery = query.Encode()
+
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, endpoint.String(), &reqBody)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.basicAuth != nil {
+		req.SetBasicAuth(c.basicAuth.Username, c.basicAuth.Password)
+	}
+	if c.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.bearerToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s %s returned %s: %s", method, endpoint.Path, resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if result == nil || len(respBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(respBody, result)
+}
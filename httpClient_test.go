@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_apiClientDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(t, r.URL.Path, "/prefix/api/v1/thing", "")
+		assertEqual(t, r.URL.Query().Get("filter"), "a=b", "")
+		assertEqual(t, r.Header.Get("Authorization"), "Bearer secret-token", "")
+		assertEqual(t, r.Header.Get("Accept"), "application/json", "")
+		if r.Method == http.MethodPost {
+			assertEqual(t, r.Header.Get("Content-Type"), "application/json", "")
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"name": "thing"}`))
+	}))
+	defer server.Close()
+
+	client, err := newAPIClient(server.URL+"/prefix", HTTPClientConfig{BearerToken: "secret-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result struct {
+		Name string `json:"name"`
+	}
+	query := url.Values{"filter": []string{"a=b"}}
+	if err := client.do(context.Background(), http.MethodGet, "/api/v1/thing", query, nil, &result); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, result.Name, "thing", "")
+
+	err = client.do(context.Background(), http.MethodPost, "/api/v1/thing", query, map[string]string{"a": "b"}, nil)
+	if err == nil {
+		t.Fatal("Expected error for status 500")
+	}
+	assertEqual(t, strings.Contains(err.Error(), "500") && strings.Contains(err.Error(), "broken"), true, err.Error())
+
+	if _, err := newAPIClient("localhost:9093", HTTPClientConfig{}); err == nil {
+		t.Error("Expected URL without scheme to be rejected")
+	}
+}
+
+// downServerURL returns the URL of a server that no longer accepts connections
+func downServerURL() string {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	return server.URL
+}
+
+func Test_alertmanagerFailover(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/api/v2/silences":
+			w.Write([]byte(`{"silenceID": "abc-123"}`))
+		case "/api/v2/alerts":
+			w.Write([]byte(`[{"labels": {"alertname": "Down"}, "status": {"state": "active"}}]`))
+		}
+	}))
+	defer server.Close()
+
+	amConfig := AlertmanagerConfig{Name: "am", ExternalURL: "http://alertmanager", URL: downServerURL(), Peers: []string{server.URL}}
+	silenceID, err := amConfig.postSilence(context.Background(), amSilence{Matchers: []amMatcher{{Name: "alertname", Value: "Down"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, silenceID, "abc-123", "")
+	alerts, err := amConfig.getAlerts(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(alerts), 1, "")
+	assertEqual(t, alerts[0].Labels["alertname"], "Down", "")
+	assertEqual(t, len(requests), 2, "every request reaches the peer once")
+
+	amConfig.Peers = nil
+	if _, err := amConfig.getAlerts(context.Background(), nil); err == nil {
+		t.Error("Expected error when no cluster member answers")
+	}
+}
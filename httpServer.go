@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultListenAddress = ":8081"
+
+// HTTPServerConfig configures the HTTP server receiving alerts
+type HTTPServerConfig struct {
+	// Address to listen on, defaults to :8081
+	ListenAddress string `yaml:"listenAddress,omitempty"`
+	// Certificate and key to serve HTTPS with. Plain HTTP is served if unset.
+	// Both files are reloaded automatically when they change
+	CertFile string `yaml:"certFile,omitempty"`
+	KeyFile  string `yaml:"keyFile,omitempty"`
+	// CA to verify client certificates with. Clients without a valid certificate are rejected if set
+	ClientCAFile string `yaml:"clientCAFile,omitempty"`
+}
+
+var httpServer *http.Server
+
+// certReloader serves a certificate and reloads it once its files change
+type certReloader struct {
+	certFile, keyFile string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := reloader.getCertificate(nil); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// getCertificate implements tls.Config.GetCertificate
+func (reloader *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	certInfo, certErr := os.Stat(reloader.certFile)
+	keyInfo, keyErr := os.Stat(reloader.keyFile)
+	if certErr != nil || keyErr != nil {
+		if reloader.cert != nil {
+			// Keep serving the old certificate while files are being replaced
+			return reloader.cert, nil
+		}
+		return nil, fmt.Errorf("unable to access certificate %s or key %s", reloader.certFile, reloader.keyFile)
+	}
+	if reloader.cert != nil && certInfo.ModTime().Equal(reloader.certTime) && keyInfo.ModTime().Equal(reloader.keyTime) {
+		return reloader.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		if reloader.cert != nil {
+			log.Errorf("Failed to reload certificate, keeping the old one: %s", err)
+			return reloader.cert, nil
+		}
+		return nil, err
+	}
+	if reloader.cert != nil {
+		log.Infof("Reloaded certificate %s", reloader.certFile)
+	}
+	reloader.cert = &cert
+	reloader.certTime = certInfo.ModTime()
+	reloader.keyTime = keyInfo.ModTime()
+	return reloader.cert, nil
+}
+
+func newTLSServerConfig(serverConfig HTTPServerConfig) (*tls.Config, error) {
+	reloader, err := newCertReloader(serverConfig.CertFile, serverConfig.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if serverConfig.ClientCAFile != "" {
+		caCert, err := ioutil.ReadFile(serverConfig.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read client CA file %s: %s", serverConfig.ClientCAFile, err)
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", serverConfig.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = caPool
+		// Health checks and the dashboard's browsers come without certificates, see requireClientCert
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// requireClientCert rejects requests without a client certificate signed by the client CA.
+// The TLS handshake already rejected invalid ones
+func requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			log.WithField("remote_addr", r.RemoteAddr).WithField("path", r.URL.Path).Warnln("Rejecting request without client certificate")
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newHTTPMux registers all of botanist's HTTP handlers. With a client CA, webhooks,
+// the admin API and metrics need a client certificate
+func newHTTPMux(serverConfig HTTPServerConfig) *http.ServeMux {
+	clientCert := func(handler http.Handler) http.Handler {
+		if serverConfig.ClientCAFile == "" {
+			return handler
+		}
+		return requireClientCert(handler)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/alert", clientCert(instrumentWebhook("alert", authenticateWebhook(promAlertHandler))))
+	mux.Handle("/grafana", clientCert(instrumentWebhook("grafana", authenticateWebhook(grafanaAlertHandler))))
+	mux.Handle("/ingest/", clientCert(instrumentWebhook("ingest", authenticateWebhook(ingestHandler))))
+	mux.Handle(adminAPIPrefix, clientCert(authenticateAdmin(adminAPIHandler)))
+	mux.Handle("/metrics", clientCert(promhttp.Handler()))
+	mux.HandleFunc(dashboardPrefix, dashboardHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	return mux
+}
+
+func newHTTPServer(serverConfig HTTPServerConfig) (*http.Server, error) {
+	listenAddress := serverConfig.ListenAddress
+	if listenAddress == "" {
+		listenAddress = defaultListenAddress
+	}
+	server := &http.Server{
+		Addr:              listenAddress,
+		Handler:           newHTTPMux(serverConfig),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		WriteTimeout:      time.Minute,
+	}
+	if serverConfig.CertFile == "" && serverConfig.KeyFile == "" {
+		if serverConfig.ClientCAFile != "" {
+			return nil, fmt.Errorf("clientCAFile requires certFile and keyFile to be set")
+		}
+		return server, nil
+	}
+	tlsConfig, err := newTLSServerConfig(serverConfig)
+	if err != nil {
+		return nil, err
+	}
+	server.TLSConfig = tlsConfig
+	return server, nil
+}
+
+// startHTTPServer sets up the HTTP server and serves it in the background.
+// The returned channel receives the error that stopped the server, if any
+func startHTTPServer() <-chan error {
+	var err error
+	httpServer, err = newHTTPServer(currentConfig().HTTP)
+	if err != nil {
+		log.Fatalf("Error when setting up HTTP server: %s", err)
+	}
+	if len(currentConfig().WebhookSenders) == 0 {
+		if currentConfig().AllowUnauthenticated {
+			log.Warnln("No webhookSenders configured - accepting alerts from anyone")
+		} else {
+			log.Errorln("No webhookSenders configured - rejecting all alerts. Set allowUnauthenticated to accept alerts from anyone")
+		}
+	}
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		var err error
+		if httpServer.TLSConfig != nil {
+			log.Infof("Starting HTTPS server on %s", httpServer.Addr)
+			// Certificates are provided by TLSConfig.GetCertificate
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			log.Infof("Starting HTTP server on %s", httpServer.Addr)
+			err = httpServer.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			serverErrors <- err
+		}
+	}()
+	return serverErrors
+}
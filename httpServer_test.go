@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate issues a certificate for 127.0.0.1, signed by parent or self-signed if parent is nil
+func testCertificate(t *testing.T, serial int64, parent *tls.Certificate) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "botanist test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  parent == nil,
+	}
+	issuer, signer := template, interface{}(key)
+	if parent != nil {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// writeTestCertificate writes the certificate and its key as PEM files with the given modification time
+func writeTestCertificate(t *testing.T, cert *tls.Certificate, certFile, keyFile string, modTime time.Time) {
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: cert.Certificate[0]},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serveTestHTTPServer serves the server on a random local port and returns its URL
+func serveTestHTTPServer(t *testing.T, server *http.Server) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeTLS(listener, "", "")
+	return "https://" + listener.Addr().String()
+}
+
+func Test_httpServerCertificateReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "botanist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	writeTestCertificate(t, testCertificate(t, 1, nil), certFile, keyFile, time.Now().Add(-time.Minute))
+
+	server, err := newHTTPServer(HTTPServerConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	serverURL := serveTestHTTPServer(t, server)
+
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	servedSerial := func() int64 {
+		resp, err := client.Get(serverURL + "/healthz")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.TLS.PeerCertificates[0].SerialNumber.Int64()
+	}
+	assertEqual(t, servedSerial(), int64(1), "")
+
+	writeTestCertificate(t, testCertificate(t, 2, nil), certFile, keyFile, time.Now())
+	assertEqual(t, servedSerial(), int64(2), "the rotated certificate is served")
+
+	// A broken certificate does not replace the working one
+	if err := ioutil.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, servedSerial(), int64(2), "")
+}
+
+func Test_httpServerClientCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "botanist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := testCertificate(t, 1, nil)
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+	writeTestCertificate(t, testCertificate(t, 2, ca), certFile, keyFile, time.Now())
+	writeTestCertificate(t, ca, caFile, filepath.Join(dir, "ca.key"), time.Now())
+
+	server, err := newHTTPServer(HTTPServerConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	serverURL := serveTestHTTPServer(t, server)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	get := func(path string, certificates ...tls.Certificate) int {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certificates},
+		}}
+		resp, err := client.Get(serverURL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assertEqual(t, get("/healthz"), http.StatusOK, "health checks need no client certificate")
+	assertEqual(t, get("/metrics"), http.StatusForbidden, "")
+	assertEqual(t, get("/metrics", *testCertificate(t, 3, ca)), http.StatusOK, "")
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	amTemplate "github.com/prometheus/alertmanager/template"
+	"github.com/prometheus/common/model"
+)
+
+// IngestSource maps arbitrary JSON posted to /ingest/<name> to an alert.
+// All mappings are Go templates executed with the decoded JSON body as data,
+// e.g. `{{ .build.status }}` or `{{ index .jobs 0 "name" }}`
+type IngestSource struct {
+	// Name of the source, used in the URL
+	Name string `yaml:"name"`
+	// Alert group the alerts are sent to, defaults to the source name
+	AlertGroup string `yaml:"alertGroup,omitempty"`
+
+	AlertName string            `yaml:"alertName"`
+	Status    string            `yaml:"status,omitempty"`
+	Summary   string            `yaml:"summary,omitempty"`
+	Link      string            `yaml:"link,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+	// Values of status that resolve the alert, defaults to resolved, ok, success and passed.
+	// Every other value (or an empty status template) fires it
+	ResolvedStatuses []string `yaml:"resolvedStatuses,omitempty"`
+}
+
+var defaultResolvedStatuses = []string{"resolved", "ok", "success", "passed"}
+
+func getIngestSource(name string) (IngestSource, bool) {
+	for _, source := range currentConfig().IngestSources {
+		if source.Name == name {
+			return source, true
+		}
+	}
+	return IngestSource{}, false
+}
+
+// render executes a mapping template against the body
+func renderMapping(name, text string, body interface{}) (string, error) {
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := template.New(name).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s template: %s", name, err)
+	}
+	var result bytes.Buffer
+	if err := tmpl.Execute(&result, body); err != nil {
+		return "", fmt.Errorf("unable to render %s: %s", name, err)
+	}
+	// Missing keys of a map[string]interface{} render as <no value>
+	return strings.TrimSpace(strings.Replace(result.String(), "<no value>", "", -1)), nil
+}
+
+// toWebhookMessage maps the body to a webhook with a single alert
+func (source IngestSource) toWebhookMessage(body interface{}, now time.Time) (*notify.WebhookMessage, error) {
+	alertName, err := renderMapping("alertName", source.AlertName, body)
+	if err != nil {
+		return nil, err
+	}
+	if alertName == "" {
+		return nil, fmt.Errorf("alertName mapping of source %s rendered empty", source.Name)
+	}
+	labels := amTemplate.KV{"alertname": alertName, "source": source.Name}
+	for label, mapping := range source.Labels {
+		value, err := renderMapping("label "+label, mapping, body)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			labels[label] = value
+		}
+	}
+	status, err := renderMapping("status", source.Status, body)
+	if err != nil {
+		return nil, err
+	}
+	summary, err := renderMapping("summary", source.Summary, body)
+	if err != nil {
+		return nil, err
+	}
+	link, err := renderMapping("link", source.Link, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvedStatuses := source.ResolvedStatuses
+	if len(resolvedStatuses) == 0 {
+		resolvedStatuses = defaultResolvedStatuses
+	}
+	alert := amTemplate.Alert{
+		Status:       string(model.AlertFiring),
+		Labels:       labels,
+		Annotations:  amTemplate.KV{"summary": summary},
+		StartsAt:     now,
+		GeneratorURL: link,
+	}
+	for _, resolved := range resolvedStatuses {
+		if strings.EqualFold(status, resolved) {
+			alert.Status = string(model.AlertResolved)
+			alert.EndsAt = now
+		}
+	}
+	// Attach to the occurrence that is already firing so that history sees one occurrence
+	if startsAt, ok := lastFiringStart(alertFingerprint(labels)); ok {
+		alert.StartsAt = startsAt
+	}
+
+	receiver := source.AlertGroup
+	if receiver == "" {
+		receiver = source.Name
+	}
+	return &notify.WebhookMessage{
+		Data: &amTemplate.Data{
+			Receiver:     receiver,
+			Status:       alert.Status,
+			Alerts:       amTemplate.Alerts{alert},
+			GroupLabels:  amTemplate.KV{"alertname": alertName},
+			CommonLabels: labels,
+		},
+		Version:  "4",
+		GroupKey: fmt.Sprintf("ingest/%s:%s", source.Name, alertName),
+	}, nil
+}
+
+func ingestHandler(w http.ResponseWriter, r *http.Request) {
+	sourceName := strings.TrimPrefix(r.URL.Path, "/ingest/")
+	reqLog := log.WithField("remote_addr", r.RemoteAddr).WithField("source", sourceName)
+	if r.Method != http.MethodPost {
+		reqLog.Errorf("Method %s not allowed", r.Method)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	source, ok := getIngestSource(sourceName)
+	if !ok {
+		reqLog.Errorln("Unknown ingest source")
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+
+	var body interface{}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		reqLog.WithError(err).Error("Failed to decode request body")
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+	msg, err := source.toWebhookMessage(body, time.Now())
+	if err != nil {
+		reqLog.WithError(err).Error("Failed to map request body to an alert")
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	notifySubscribers(msg, fmt.Sprintf("%s alert", source.Name), "", reqLog)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testIngestSource = IngestSource{
+	Name:      "ci",
+	AlertName: "Build {{ .project.name }}",
+	Status:    "{{ .build.status }}",
+	Summary:   "{{ .build.status }}: {{ index .build.stages 0 }}",
+	Link:      "{{ .build.url }}",
+	Labels: map[string]string{
+		"branch": "{{ .build.branch }}",
+		"runner": "{{ .build.runner }}",
+	},
+}
+
+func Test_ingestMapping(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	var body interface{}
+	err := json.Unmarshal([]byte(`{
+		"project": {"name": "botanist"},
+		"build": {"status": "failed", "branch": "master", "url": "https://ci/1", "stages": ["test"]}
+	}`), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firingAt := time.Date(2019, 2, 14, 10, 0, 0, 0, time.UTC)
+	msg, err := testIngestSource.toWebhookMessage(body, firingAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, msg.Receiver, "ci", "")
+	assertEqual(t, len(msg.Alerts), 1, "")
+	alert := msg.Alerts[0]
+	assertEqual(t, alert.Status, "firing", "")
+	assertEqual(t, alert.Labels["alertname"], "Build botanist", "")
+	assertEqual(t, alert.Labels["branch"], "master", "")
+	_, hasRunner := alert.Labels["runner"]
+	assertEqual(t, hasRunner, false, "")
+	assertEqual(t, alert.Annotations["summary"], "failed: test", "")
+	assertEqual(t, alert.GeneratorURL, "https://ci/1", "")
+	if err := recordWebhook(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// The resolving request belongs to the occurrence that fired before
+	body.(map[string]interface{})["build"].(map[string]interface{})["status"] = "success"
+	msg, err = testIngestSource.toWebhookMessage(body, firingAt.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, msg.Alerts[0].Status, "resolved", "")
+	assertEqual(t, msg.Alerts[0].StartsAt, firingAt, "")
+}
+
+func Test_ingestHandler(t *testing.T) {
+	oldSources := botanistConfig.IngestSources
+	defer func() { botanistConfig.IngestSources = oldSources }()
+	botanistConfig.IngestSources = []IngestSource{testIngestSource}
+
+	for path, test := range map[string]struct {
+		body string
+		code int
+	}{
+		"/ingest/ci":      {`{"project": {"name": "botanist"}, "build": {"status": "failed", "stages": ["test"]}}`, http.StatusOK},
+		"/ingest/unknown": {`{}`, http.StatusNotFound},
+		"/ingest/ci/":     {`{}`, http.StatusNotFound},
+	} {
+		req, err := http.NewRequest("POST", path, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(ingestHandler).ServeHTTP(rr, req)
+		assertEqual(t, rr.Code, test.code, path)
+	}
+
+	// Bodies the mapping does not fit are rejected
+	req, err := http.NewRequest("POST", "/ingest/ci", strings.NewReader(`{"build": {"stages": ["test"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(ingestHandler).ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, http.StatusUnprocessableEntity, "")
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	webhooksReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_webhooks_received_total",
+		Help: "Number of webhooks received per endpoint.",
+	}, []string{"endpoint"})
+	webhooksRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_webhooks_rejected_total",
+		Help: "Number of webhooks rejected per endpoint and HTTP status code.",
+	}, []string{"endpoint", "code"})
+	webhookDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "botanist_webhook_duration_seconds",
+		Help:    "Time it took to process a webhook.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+	alertsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_alerts_received_total",
+		Help: "Number of alerts received per receiver.",
+	}, []string{"receiver"})
+	messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_messages_sent_total",
+		Help: "Number of messages successfully sent per backend.",
+	}, []string{"backend"})
+	messagesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_messages_failed_total",
+		Help: "Number of messages that could not be sent per backend.",
+	}, []string{"backend"})
+	commandInvocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_command_invocations_total",
+		Help: "Number of chat commands handled per command.",
+	}, []string{"command"})
+	buttonClicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_button_clicks_total",
+		Help: "Number of button clicks per callback.",
+	}, []string{"callback"})
+	silencesCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_silences_created_total",
+		Help: "Number of silences created per Alertmanager.",
+	}, []string{"alertmanager"})
+	silencesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_silences_failed_total",
+		Help: "Number of silences that could not be created per Alertmanager.",
+	}, []string{"alertmanager"})
+	outboxRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_outbox_retries_total",
+		Help: "Number of message deliveries that failed and were scheduled for another attempt.",
+	}, []string{"backend"})
+	outboxDeliveriesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_outbox_deliveries_failed_total",
+		Help: "Number of messages given up after all attempts failed.",
+	}, []string{"backend"})
+	outboxPending = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "botanist_outbox_pending_messages",
+		Help: "Number of messages waiting to be delivered.",
+	}, func() float64 { return float64(messageOutbox.pending()) })
+	pubsubReceiveLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "botanist_pubsub_receive_latency_seconds",
+		Help:    "Time between publishing and receiving a Pub/Sub message.",
+		Buckets: prometheus.DefBuckets,
+	})
+	escalationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_escalations_sent_total",
+		Help: "Number of unacknowledged alerts escalated per policy and level.",
+	}, []string{"policy", "level"})
+	remindersSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_reminders_sent_total",
+		Help: "Number of reminders about long-firing alerts per receiver.",
+	}, []string{"receiver"})
+	notificationsDeferred = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "botanist_notifications_deferred_total",
+		Help: "Number of non-critical notifications deferred into a digest because the user was quiet.",
+	})
+	permissionDenials = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_permission_denials_total",
+		Help: "Number of commands and button clicks denied per command or callback.",
+	}, []string{"action"})
+	configReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "botanist_config_reloads_total",
+		Help: "Number of configuration reloads per result.",
+	}, []string{"result"})
+	configLastReloadSuccessful = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "botanist_config_last_reload_successful",
+		Help: "Whether the last configuration reload succeeded.",
+	})
+	configLastReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "botanist_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload.",
+	})
+)
+
+// subscriberCollector exposes the number of subscribers of every alert group
+type subscriberCollector struct {
+	desc *prometheus.Desc
+}
+
+func newSubscriberCollector() *subscriberCollector {
+	return &subscriberCollector{
+		desc: prometheus.NewDesc(
+			"botanist_alert_group_subscribers",
+			"Number of subscribers per alert group and backend.",
+			[]string{"alertgroup", "backend"}, nil,
+		),
+	}
+}
+
+func (collector *subscriberCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.desc
+}
+
+func (collector *subscriberCollector) Collect(ch chan<- prometheus.Metric) {
+	subs, err := subscriptions.All()
+	if err != nil {
+		log.Errorf("Could not read subscriptions for metrics: %s", err)
+		return
+	}
+	counts := make(map[[2]string]int)
+	for _, sub := range subs {
+		counts[[2]string{sub.AlertGroup, sub.Backend}]++
+	}
+	for groupBackend, count := range counts {
+		ch <- prometheus.MustNewConstMetric(collector.desc, prometheus.GaugeValue, float64(count), groupBackend[0], groupBackend[1])
+	}
+}
+
+func init() {
+	prometheus.MustRegister(
+		webhooksReceived,
+		webhooksRejected,
+		webhookDuration,
+		alertsReceived,
+		messagesSent,
+		messagesFailed,
+		commandInvocations,
+		buttonClicks,
+		silencesCreated,
+		silencesFailed,
+		outboxRetries,
+		outboxDeliveriesFailed,
+		outboxPending,
+		pubsubReceiveLatency,
+		escalationsSent,
+		remindersSent,
+		notificationsDeferred,
+		permissionDenials,
+		configReloads,
+		configLastReloadSuccessful,
+		configLastReloadTime,
+		newSubscriberCollector(),
+	)
+}
+
+// countMessage counts the result of sending a message to a backend
+func countMessage(backend string, err error) {
+	if err != nil {
+		messagesFailed.WithLabelValues(backend).Inc()
+		return
+	}
+	messagesSent.WithLabelValues(backend).Inc()
+}
+
+// statusRecorder remembers the status code a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	recorder.status = status
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+// receiverLabel is the receiver label of an alert group. Alert groups without subscribers
+// share the label "other", so webhooks cannot create arbitrarily many series
+func receiverLabel(receiver string) string {
+	subs, err := subscriptions.Subscribers(receiver)
+	if err != nil || len(subs) == 0 {
+		return "other"
+	}
+	return receiver
+}
+
+// instrumentWebhook counts and times the requests to a webhook endpoint
+func instrumentWebhook(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(recorder, r)
+
+		webhooksReceived.WithLabelValues(endpoint).Inc()
+		if recorder.status >= http.StatusBadRequest {
+			webhooksRejected.WithLabelValues(endpoint, strconv.Itoa(recorder.status)).Inc()
+		}
+		webhookDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import "testing"
+
+func Test_receiverLabel(t *testing.T) {
+	oldSubscriptions := subscriptions
+	defer func() { subscriptions = oldSubscriptions }()
+	subscriptions = newFileSubscriptionStore("")
+	if err := subscriptions.Subscribe(Subscription{AlertGroup: "wakeup", Backend: "hangouts", Userinfo: Userinfo{MessagePath: "spaces/jane"}}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, receiverLabel("wakeup"), "wakeup", "")
+	assertEqual(t, receiverLabel("made-up-receiver"), "other", "")
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	sessionCookie    = "botanist_session"
+	loginStateCookie = "botanist_login"
+	sessionDuration  = 12 * time.Hour
+)
+
+// oidcDiscovery is the part of the OpenID provider metadata botanist uses
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+}
+
+var (
+	oidcMu sync.Mutex
+	// discovered provider metadata per issuer
+	oidcProviders = make(map[string]*oidcDiscovery)
+
+	randomSessionSecret     []byte
+	randomSessionSecretOnce sync.Once
+)
+
+// requireTLS rejects endpoints the ID token could be tampered with on the way from,
+// as its signature is not verified. Plain HTTP is only allowed to loopback addresses
+func requireTLS(endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme == "https" {
+		return nil
+	}
+	if parsed.Scheme == "http" {
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must use https", endpoint)
+}
+
+// discoverOIDC fetches the metadata of an issuer once
+func discoverOIDC(ctx context.Context, issuer string) (*oidcDiscovery, error) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	if provider, ok := oidcProviders[issuer]; ok {
+		return provider, nil
+	}
+	if err := requireTLS(issuer); err != nil {
+		return nil, fmt.Errorf("OIDC issuer %s", err)
+	}
+	client, err := newAPIClient(issuer, HTTPClientConfig{})
+	if err != nil {
+		return nil, err
+	}
+	provider := &oidcDiscovery{}
+	if err := client.do(ctx, http.MethodGet, "/.well-known/openid-configuration", nil, nil, provider); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %s", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %s instead of %s", provider.Issuer, issuer)
+	}
+	if err := requireTLS(provider.TokenEndpoint); err != nil {
+		return nil, fmt.Errorf("OIDC token endpoint %s", err)
+	}
+	oidcProviders[issuer] = provider
+	return provider, nil
+}
+
+// oauth2Config returns the OAuth2 client of the dashboard
+func (dashboardConfig DashboardConfig) oauth2Config(provider *oidcDiscovery) (*oauth2.Config, error) {
+	clientSecret, err := readSecret(dashboardConfig.ClientSecret, dashboardConfig.ClientSecretFile)
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Config{
+		ClientID:     dashboardConfig.ClientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  dashboardConfig.RedirectURL,
+		Endpoint:     oauth2.Endpoint{AuthURL: provider.AuthorizationEndpoint, TokenURL: provider.TokenEndpoint},
+		Scopes:       []string{"openid", "email", "profile"},
+	}, nil
+}
+
+// audience is the aud claim, which may be a single string or a list
+type audience []string
+
+func (aud *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*aud = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*aud = list
+	return nil
+}
+
+// idTokenClaims are the claims of an OIDC ID token botanist uses
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// parseIDToken validates the claims of an ID token.
+// The token is received directly from the token endpoint over TLS (see requireTLS),
+// so the signature does not need to be verified (OpenID Connect Core 3.1.3.7)
+func parseIDToken(rawToken string, provider *oidcDiscovery, clientID, nonce string, now time.Time) (*idTokenClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token payload: %s", err)
+	}
+	claims := &idTokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %s", err)
+	}
+	if claims.Issuer != provider.Issuer {
+		return nil, fmt.Errorf("ID token issued by %s instead of %s", claims.Issuer, provider.Issuer)
+	}
+	audienceValid := false
+	for _, aud := range claims.Audience {
+		audienceValid = audienceValid || aud == clientID
+	}
+	if !audienceValid {
+		return nil, fmt.Errorf("ID token is not meant for client %s", clientID)
+	}
+	if now.After(time.Unix(claims.Expiry, 0)) {
+		return nil, fmt.Errorf("ID token expired")
+	}
+	if !secureCompare(claims.Nonce, nonce) {
+		return nil, fmt.Errorf("ID token nonce does not match")
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return nil, fmt.Errorf("email %s is not verified", claims.Email)
+	}
+	return claims, nil
+}
+
+// sessionSecret returns the key session cookies are signed with
+func (dashboardConfig DashboardConfig) sessionSecret() ([]byte, error) {
+	secret, err := readSecret(dashboardConfig.SessionSecret, dashboardConfig.SessionSecretFile)
+	if err != nil || secret != "" {
+		return []byte(secret), err
+	}
+	randomSessionSecretOnce.Do(func() {
+		randomSessionSecret = make([]byte, 32)
+		if _, err := rand.Read(randomSessionSecret); err != nil {
+			log.Fatalf("Unable to generate session secret: %s", err)
+		}
+		log.Warnln("No dashboard sessionSecret configured - sessions will not survive a restart")
+	})
+	return randomSessionSecret, nil
+}
+
+// randomString returns a random URL safe string for states and nonces
+func randomString() (string, error) {
+	data := make([]byte, 24)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// signCookieValue encodes v as JSON and appends a signature
+func signCookieValue(secret []byte, v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyCookieValue checks the signature of a value created by signCookieValue and decodes it into v
+func verifyCookieValue(secret []byte, value string, v interface{}) error {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return fmt.Errorf("malformed cookie")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("invalid cookie signature")
+	}
+	return json.Unmarshal(data, v)
+}
+
+// dashboardSession is the logged in dashboard user
+type dashboardSession struct {
+	Subject string    `json:"sub"`
+	Email   string    `json:"email"`
+	Name    string    `json:"name"`
+	Expires time.Time `json:"exp"`
+}
+
+// loginState protects the login against CSRF and token replay
+type loginState struct {
+	State   string    `json:"state"`
+	Nonce   string    `json:"nonce"`
+	Expires time.Time `json:"exp"`
+}
+
+// allowed tells if the logged in user may see the dashboard
+func (dashboardConfig DashboardConfig) allowed(session *dashboardSession) bool {
+	if len(dashboardConfig.AllowedDomains) == 0 {
+		return true
+	}
+	for _, domain := range dashboardConfig.AllowedDomains {
+		if strings.HasSuffix(strings.ToLower(session.Email), "@"+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (dashboardConfig DashboardConfig) setCookie(w http.ResponseWriter, name, value string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     dashboardPrefix,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(dashboardConfig.RedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// currentSession returns the valid session of the request, if any
+func (dashboardConfig DashboardConfig) currentSession(r *http.Request) *dashboardSession {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	secret, err := dashboardConfig.sessionSecret()
+	if err != nil {
+		log.Errorf("Unable to read dashboard session secret: %s", err)
+		return nil
+	}
+	session := &dashboardSession{}
+	if err := verifyCookieValue(secret, cookie.Value, session); err != nil || time.Now().After(session.Expires) {
+		return nil
+	}
+	return session
+}
+
+// startLogin returns the URL of the OIDC provider's login page and the signed login state
+func (dashboardConfig DashboardConfig) startLogin(ctx context.Context) (string, string, error) {
+	provider, err := discoverOIDC(ctx, dashboardConfig.Issuer)
+	if err != nil {
+		return "", "", err
+	}
+	oauthConfig, err := dashboardConfig.oauth2Config(provider)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := dashboardConfig.sessionSecret()
+	if err != nil {
+		return "", "", err
+	}
+	state, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return "", "", err
+	}
+	value, err := signCookieValue(secret, loginState{State: state, Nonce: nonce, Expires: time.Now().Add(10 * time.Minute)})
+	if err != nil {
+		return "", "", err
+	}
+	return oauthConfig.AuthCodeURL(state, oauth2.SetAuthURLParam("nonce", nonce)), value, nil
+}
+
+// loginHandler sends the user to the OIDC provider
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	dashboardConfig := currentConfig().Dashboard
+	authURL, login, err := dashboardConfig.startLogin(r.Context())
+	if err != nil {
+		log.Errorf("Dashboard login failed: %s", err)
+		http.Error(w, "Login not available", http.StatusBadGateway)
+		return
+	}
+	dashboardConfig.setCookie(w, loginStateCookie, login, time.Now().Add(10*time.Minute))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// callbackHandler completes the login once the OIDC provider sends the user back
+func callbackHandler(w http.ResponseWriter, r *http.Request) {
+	dashboardConfig := currentConfig().Dashboard
+	reqLog := log.WithField("remote_addr", r.RemoteAddr)
+	fail := func(status int, message string, err error) {
+		reqLog.Warnf("Dashboard login failed: %s", err)
+		http.Error(w, message, status)
+	}
+
+	secret, err := dashboardConfig.sessionSecret()
+	if err != nil {
+		fail(http.StatusInternalServerError, "", err)
+		return
+	}
+	var login loginState
+	cookie, err := r.Cookie(loginStateCookie)
+	if err == nil {
+		err = verifyCookieValue(secret, cookie.Value, &login)
+	}
+	if err != nil || time.Now().After(login.Expires) || !secureCompare(r.URL.Query().Get("state"), login.State) {
+		fail(http.StatusBadRequest, "Login expired, please try again", fmt.Errorf("invalid login state"))
+		return
+	}
+	dashboardConfig.setCookie(w, loginStateCookie, "", time.Unix(0, 0))
+	if errorCode := r.URL.Query().Get("error"); errorCode != "" {
+		fail(http.StatusForbidden, "Login denied", fmt.Errorf("%s: %s", errorCode, r.URL.Query().Get("error_description")))
+		return
+	}
+
+	provider, err := discoverOIDC(r.Context(), dashboardConfig.Issuer)
+	if err != nil {
+		fail(http.StatusBadGateway, "Login provider not available", err)
+		return
+	}
+	oauthConfig, err := dashboardConfig.oauth2Config(provider)
+	if err != nil {
+		fail(http.StatusInternalServerError, "", err)
+		return
+	}
+	token, err := oauthConfig.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		fail(http.StatusBadGateway, "Login failed", err)
+		return
+	}
+	rawIDToken, _ := token.Extra("id_token").(string)
+	claims, err := parseIDToken(rawIDToken, provider, dashboardConfig.ClientID, login.Nonce, time.Now())
+	if err != nil {
+		fail(http.StatusForbidden, "Login failed", err)
+		return
+	}
+
+	session := &dashboardSession{Subject: claims.Subject, Email: claims.Email, Name: claims.Name, Expires: time.Now().Add(sessionDuration)}
+	if session.Name == "" {
+		session.Name = session.Email
+	}
+	if !dashboardConfig.allowed(session) {
+		fail(http.StatusForbidden, "You are not allowed to use this dashboard", fmt.Errorf("%s is not in an allowed domain", session.Email))
+		return
+	}
+	value, err := signCookieValue(secret, session)
+	if err != nil {
+		fail(http.StatusInternalServerError, "", err)
+		return
+	}
+	dashboardConfig.setCookie(w, sessionCookie, value, session.Expires)
+	reqLog.Infof("%s logged in to the dashboard", session.Email)
+	http.Redirect(w, r, dashboardPrefix, http.StatusFound)
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	currentConfig().Dashboard.setCookie(w, sessionCookie, "", time.Unix(0, 0))
+	fmt.Fprintln(w, "Logged out")
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sbstjn/allot"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// on-call schedules created via chat
+	scheduleBucket = []byte("schedules")
+	// overrides of on-call schedules set via chat, keyed by schedule and sequence
+	overrideBucket = []byte("overrides")
+)
+
+// onCallBackend is the subscription backend routing alert groups to whoever is on call for a schedule
+const onCallBackend = "oncall"
+
+// OnCallSchedule is a rotation of participants taking shifts of ShiftDays days
+type OnCallSchedule struct {
+	Name string `yaml:"name" json:"name"`
+	// Time zone of start and handoff, defaults to UTC
+	TimeZone string `yaml:"timeZone,omitempty" json:"timeZone,omitempty"`
+	// Day the first participant's first shift starts, e.g. 2019-01-07
+	Start string `yaml:"start" json:"start"`
+	// Time of day shifts are handed over, defaults to 09:00
+	Handoff string `yaml:"handoff,omitempty" json:"handoff,omitempty"`
+	// Length of a shift in days, defaults to a week
+	ShiftDays    int                 `yaml:"shiftDays,omitempty" json:"shiftDays,omitempty"`
+	Participants []OnCallParticipant `yaml:"participants" json:"participants"`
+	// Take precedence over the rotation, the last matching override wins
+	Overrides []OnCallOverride `yaml:"overrides,omitempty" json:"overrides,omitempty"`
+}
+
+// OnCallParticipant is somebody taking shifts
+type OnCallParticipant struct {
+	Name string `yaml:"name" json:"name"`
+	// Hangouts space (e.g. the DM with botanist) the participant is notified in
+	MessagePath string `yaml:"messagePath" json:"messagePath"`
+	// Hangouts user (e.g. users/123), needed to recognize the participant in chat commands
+	Username string `yaml:"username,omitempty" json:"username,omitempty"`
+	Email    string `yaml:"email,omitempty" json:"email,omitempty"`
+}
+
+// OnCallOverride puts somebody on call from Start until End.
+// In the config file only the name of a participant is needed
+type OnCallOverride struct {
+	OnCallParticipant `yaml:",inline"`
+	Start             time.Time `yaml:"start" json:"start"`
+	End               time.Time `yaml:"end" json:"end"`
+}
+
+// onCallShift is who is on call for a schedule from Start until End
+type onCallShift struct {
+	OnCallParticipant
+	Start, End time.Time
+	Override   bool
+}
+
+// OnCallUser implements User for whoever is on call for a schedule when a message is delivered.
+// Its MessagePath is the name of the schedule
+type OnCallUser struct {
+	*Userinfo
+}
+
+func (participant OnCallParticipant) user() HangoutsUser {
+	username := participant.Username
+	if username == "" {
+		username = participant.MessagePath
+	}
+	return HangoutsUser{&Userinfo{MessagePath: participant.MessagePath, Username: username, FriendlyName: participant.Name, Email: participant.Email}}
+}
+
+func (schedule OnCallSchedule) location() (*time.Location, error) {
+	if schedule.TimeZone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(schedule.TimeZone)
+}
+
+func (schedule OnCallSchedule) shiftDays() int {
+	if schedule.ShiftDays == 0 {
+		return 7
+	}
+	return schedule.ShiftDays
+}
+
+// firstHandoff is when the first shift of the schedule starts
+func (schedule OnCallSchedule) firstHandoff() (time.Time, error) {
+	loc, err := schedule.location()
+	if err != nil {
+		return time.Time{}, err
+	}
+	handoff := schedule.Handoff
+	if handoff == "" {
+		handoff = "09:00"
+	}
+	return time.ParseInLocation("2006-01-02 15:04", schedule.Start+" "+handoff, loc)
+}
+
+func (schedule OnCallSchedule) validate() error {
+	if schedule.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if _, err := schedule.firstHandoff(); err != nil {
+		return fmt.Errorf("%s: invalid start, handoff or timeZone: %s", schedule.Name, err)
+	}
+	if schedule.ShiftDays < 0 {
+		return fmt.Errorf("%s: shiftDays must not be negative", schedule.Name)
+	}
+	for _, participant := range schedule.Participants {
+		if participant.Name == "" || participant.MessagePath == "" {
+			return fmt.Errorf("%s: participants need a name and messagePath", schedule.Name)
+		}
+	}
+	for _, override := range schedule.Overrides {
+		if _, ok := schedule.participant(override.Name); !ok && override.MessagePath == "" {
+			return fmt.Errorf("%s: override for unknown participant %s", schedule.Name, override.Name)
+		}
+		if !override.End.After(override.Start) {
+			return fmt.Errorf("%s: override for %s ends before it starts", schedule.Name, override.Name)
+		}
+	}
+	return nil
+}
+
+func (schedule OnCallSchedule) participant(name string) (OnCallParticipant, bool) {
+	for _, participant := range schedule.Participants {
+		if strings.EqualFold(participant.Name, name) {
+			return participant, true
+		}
+	}
+	return OnCallParticipant{}, false
+}
+
+// is tells if the participant is the chat user. Participants without a username are
+// recognized by the direct message space of the user
+func (participant OnCallParticipant) is(info *Userinfo) bool {
+	if participant.Username != "" {
+		return participant.Username == info.Username
+	}
+	space, err := dmSpace(info.Username)
+	return err == nil && space != "" && space == participant.MessagePath
+}
+
+// includes tells if the user is one of the participants
+func (schedule OnCallSchedule) includes(info *Userinfo) bool {
+	for _, participant := range schedule.Participants {
+		if participant.is(info) {
+			return true
+		}
+	}
+	return false
+}
+
+// rotationShift returns the shift of the rotation at t, ignoring overrides
+func (schedule OnCallSchedule) rotationShift(t time.Time) (onCallShift, error) {
+	first, err := schedule.firstHandoff()
+	if err != nil {
+		return onCallShift{}, err
+	}
+	if len(schedule.Participants) == 0 {
+		return onCallShift{}, fmt.Errorf("schedule %s has no participants", schedule.Name)
+	}
+	days := schedule.shiftDays()
+	shiftStart := func(n int) time.Time { return first.AddDate(0, 0, n*days) }
+	// Days are not always 24h long, so correct the estimate
+	n := int(t.Sub(first).Hours() / 24 / float64(days))
+	for shiftStart(n).After(t) {
+		n--
+	}
+	for !shiftStart(n + 1).After(t) {
+		n++
+	}
+	index := n % len(schedule.Participants)
+	if index < 0 {
+		index += len(schedule.Participants)
+	}
+	return onCallShift{OnCallParticipant: schedule.Participants[index], Start: shiftStart(n), End: shiftStart(n + 1)}, nil
+}
+
+// shiftAt returns who is on call at t. Overrides set via chat take precedence over
+// those in the config file, which take precedence over the rotation
+func (schedule OnCallSchedule) shiftAt(t time.Time) (onCallShift, error) {
+	overrides, err := chatOverrides(schedule.Name)
+	if err != nil {
+		return onCallShift{}, err
+	}
+	for _, layer := range [][]OnCallOverride{overrides, schedule.Overrides} {
+		for i := len(layer) - 1; i >= 0; i-- {
+			override := layer[i]
+			if t.Before(override.Start) || !t.Before(override.End) {
+				continue
+			}
+			participant := override.OnCallParticipant
+			if participant.MessagePath == "" {
+				participant, _ = schedule.participant(override.Name)
+			}
+			return onCallShift{OnCallParticipant: participant, Start: override.Start, End: override.End, Override: true}, nil
+		}
+	}
+	return schedule.rotationShift(t)
+}
+
+// chatSchedules returns the schedules created via chat
+func chatSchedules() ([]OnCallSchedule, error) {
+	var schedules []OnCallSchedule
+	if stateDB == nil {
+		return schedules, nil
+	}
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(scheduleBucket).ForEach(func(_, data []byte) error {
+			var schedule OnCallSchedule
+			if err := json.Unmarshal(data, &schedule); err != nil {
+				return err
+			}
+			schedules = append(schedules, schedule)
+			return nil
+		})
+	})
+	return schedules, err
+}
+
+// allSchedules returns the schedules of the config file followed by those created via chat
+func allSchedules() ([]OnCallSchedule, error) {
+	schedules, err := chatSchedules()
+	return append(append([]OnCallSchedule{}, currentConfig().Schedules...), schedules...), err
+}
+
+func getSchedule(name string) (OnCallSchedule, bool, error) {
+	schedules, err := allSchedules()
+	for _, schedule := range schedules {
+		if strings.EqualFold(schedule.Name, name) {
+			return schedule, true, nil
+		}
+	}
+	return OnCallSchedule{}, false, err
+}
+
+func configSchedule(name string) bool {
+	for _, schedule := range currentConfig().Schedules {
+		if strings.EqualFold(schedule.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func putChatSchedule(schedule OnCallSchedule) error {
+	if stateDB == nil {
+		return fmt.Errorf("schedules can only be changed via chat with a database")
+	}
+	if configSchedule(schedule.Name) {
+		return fmt.Errorf("schedule %s is defined in the config file", schedule.Name)
+	}
+	if err := schedule.validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(scheduleBucket).Put([]byte(schedule.Name), data)
+	})
+}
+
+func overridePrefix(schedule string) []byte {
+	return []byte(schedule + "/")
+}
+
+// chatOverrides returns the overrides of a schedule set via chat, oldest first
+func chatOverrides(schedule string) ([]OnCallOverride, error) {
+	var overrides []OnCallOverride
+	if stateDB == nil {
+		return overrides, nil
+	}
+	prefix := overridePrefix(schedule)
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(overrideBucket).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && strings.HasPrefix(string(key), string(prefix)); key, data = cursor.Next() {
+			var override OnCallOverride
+			if err := json.Unmarshal(data, &override); err != nil {
+				return err
+			}
+			overrides = append(overrides, override)
+		}
+		return nil
+	})
+	return overrides, err
+}
+
+// addOverride stores an override for a schedule and drops the ones that ended before now
+func addOverride(schedule string, override OnCallOverride, now time.Time) error {
+	if stateDB == nil {
+		return fmt.Errorf("overrides can only be set with a database")
+	}
+	data, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	prefix := overridePrefix(schedule)
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(overrideBucket)
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && strings.HasPrefix(string(key), string(prefix)); key, data = cursor.Next() {
+			var existing OnCallOverride
+			if err := json.Unmarshal(data, &existing); err == nil && existing.End.Before(now) {
+				expired = append(expired, append([]byte(nil), key...))
+			}
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(append(prefix, sequenceKey(seq)...), data)
+	})
+}
+
+// parseUntil understands durations like 8h or 2d, times of day like 18:00 (the next one),
+// days like 2019-02-03 (midnight) and 2019-02-03T18:00 in the given location
+func parseUntil(text string, now time.Time, loc *time.Location) (time.Time, error) {
+	local := now.In(loc)
+	if clock, err := time.ParseInLocation("15:04", text, loc); err == nil {
+		until := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+		if !until.After(now) {
+			until = until.AddDate(0, 0, 1)
+		}
+		return until, nil
+	}
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02"} {
+		if until, err := time.ParseInLocation(layout, text, loc); err == nil {
+			return until, nil
+		}
+	}
+	if strings.HasSuffix(text, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(text, "d")); err == nil {
+			return local.AddDate(0, 0, days), nil
+		}
+	}
+	if duration, err := time.ParseDuration(text); err == nil {
+		return now.Add(duration), nil
+	}
+	return time.Time{}, fmt.Errorf("I could not understand %q, try 18:00, 8h, 2d or 2019-02-03T18:00", text)
+}
+
+// participantFor turns a chat user into a participant of a schedule, who is notified in their
+// direct message space with botanist rather than in the space the command was given in
+func participantFor(user User) (OnCallParticipant, error) {
+	info := user.getUserinfo()
+	space, err := dmSpace(info.Username)
+	if err != nil {
+		return OnCallParticipant{}, err
+	}
+	if space == "" {
+		return OnCallParticipant{}, fmt.Errorf("I don't know where to notify you yet, please send me a direct message first")
+	}
+	return OnCallParticipant{Name: info.FriendlyName, MessagePath: space, Username: info.Username, Email: info.Email}, nil
+}
+
+func formatShift(schedule OnCallSchedule, shift onCallShift) string {
+	loc, _ := schedule.location()
+	text := fmt.Sprintf("%s: %s until %s", schedule.Name, shift.Name, shift.End.In(loc).Format("Mon Jan 2 15:04 MST"))
+	if shift.Override {
+		text += " (override)"
+	}
+	return text
+}
+
+func handleWhoIsOnCall(match allot.MatchInterface, User User) (*genericMessage, error) {
+	schedules, err := allSchedules()
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues reading the on-call schedules"}, err
+	}
+	if name, err := match.String("schedule"); err == nil {
+		schedule, ok, _ := getSchedule(name)
+		if !ok {
+			return &genericMessage{ContentText: fmt.Sprintf("I don't know a schedule called %s", name)}, nil
+		}
+		schedules = []OnCallSchedule{schedule}
+	}
+	if len(schedules) == 0 {
+		return &genericMessage{ContentText: "There are no on-call schedules"}, nil
+	}
+
+	now := time.Now()
+	var lines []string
+	for _, schedule := range schedules {
+		shift, err := schedule.shiftAt(now)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("%s: %s", schedule.Name, err))
+			continue
+		}
+		line := formatShift(schedule, shift)
+		if next, err := schedule.shiftAt(shift.End); err == nil {
+			line += ", then " + next.Name
+		}
+		lines = append(lines, line)
+	}
+	return &genericMessage{ContentText: strings.Join(lines, "\n")}, nil
+}
+
+// overrideSchedule finds the schedule meant by "oncall override me": the named one,
+// the only one or the only one the user takes part in
+func overrideSchedule(match allot.MatchInterface, user User) (OnCallSchedule, error) {
+	if name, err := match.String("schedule"); err == nil {
+		schedule, ok, err := getSchedule(name)
+		if err == nil && !ok {
+			err = fmt.Errorf("I don't know a schedule called %s", name)
+		}
+		return schedule, err
+	}
+	schedules, err := allSchedules()
+	if err != nil {
+		return OnCallSchedule{}, err
+	}
+	if len(schedules) == 1 {
+		return schedules[0], nil
+	}
+	var candidates []OnCallSchedule
+	for _, schedule := range schedules {
+		if schedule.includes(user.getUserinfo()) {
+			candidates = append(candidates, schedule)
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	return OnCallSchedule{}, fmt.Errorf("Which schedule? Use oncall override me on <schedule> until <time>")
+}
+
+func handleOnCallOverride(match allot.MatchInterface, User User) (*genericMessage, error) {
+	schedule, err := overrideSchedule(match, User)
+	if err != nil {
+		return &genericMessage{ContentText: err.Error()}, nil
+	}
+	untilText, _ := match.String("until")
+	loc, err := schedule.location()
+	if err != nil {
+		return &genericMessage{ContentText: err.Error()}, nil
+	}
+	now := time.Now()
+	until, err := parseUntil(untilText, now, loc)
+	if err != nil {
+		return &genericMessage{ContentText: err.Error()}, nil
+	}
+	if !until.After(now) {
+		return &genericMessage{ContentText: fmt.Sprintf("%s is in the past", until.In(loc).Format("Mon Jan 2 15:04 MST"))}, nil
+	}
+	participant, err := participantFor(User)
+	if err != nil {
+		return &genericMessage{ContentText: err.Error()}, nil
+	}
+	override := OnCallOverride{OnCallParticipant: participant, Start: now, End: until}
+	if err := addOverride(schedule.Name, override, now); err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not store the override: %s", err)}, err
+	}
+	auditUser(User, auditOnCallOverride, schedule.Name, map[string]string{"until": until.Format(time.RFC3339)})
+	return &genericMessage{ContentText: fmt.Sprintf("%s is on call for %s until %s",
+		override.Name, schedule.Name, until.In(loc).Format("Mon Jan 2 15:04 MST"))}, nil
+}
+
+// handleSchedule creates or changes a schedule managed via chat, keeping its participants
+func handleSchedule(match allot.MatchInterface, User User) (*genericMessage, error) {
+	name, _ := match.String("schedule")
+	schedule, ok, err := getSchedule(name)
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues reading the on-call schedules"}, err
+	}
+	if !ok {
+		schedule = OnCallSchedule{Name: name}
+	}
+	schedule.Start, _ = match.String("start")
+	schedule.Handoff, _ = match.String("handoff")
+	schedule.TimeZone, _ = match.String("timezone")
+	if err := putChatSchedule(schedule); err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not save schedule %s: %s", name, err)}, nil
+	}
+	auditUser(User, auditScheduleChanged, schedule.Name, map[string]string{"start": schedule.Start, "handoff": schedule.Handoff, "timeZone": schedule.TimeZone})
+	return &genericMessage{ContentText: fmt.Sprintf("Schedule %s hands over every week at %s %s, starting %s. Use oncall join %s to take part",
+		schedule.Name, schedule.Handoff, schedule.TimeZone, schedule.Start, schedule.Name)}, nil
+}
+
+// handleJoinSchedule adds the user to the end of a rotation managed via chat
+func handleJoinSchedule(match allot.MatchInterface, User User) (*genericMessage, error) {
+	name, _ := match.String("schedule")
+	schedule, ok, err := getSchedule(name)
+	if err != nil || !ok {
+		return &genericMessage{ContentText: fmt.Sprintf("I don't know a schedule called %s", name)}, err
+	}
+	if schedule.includes(User.getUserinfo()) {
+		return &genericMessage{ContentText: fmt.Sprintf("You already take part in %s", schedule.Name)}, nil
+	}
+	participant, err := participantFor(User)
+	if err != nil {
+		return &genericMessage{ContentText: err.Error()}, nil
+	}
+	schedule.Participants = append(schedule.Participants, participant)
+	if err := putChatSchedule(schedule); err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not add you to %s: %s", schedule.Name, err)}, nil
+	}
+	auditUser(User, auditScheduleJoined, schedule.Name, nil)
+	return &genericMessage{ContentText: fmt.Sprintf("%s joined the rotation of %s as number %d", User.getUserinfo().FriendlyName, schedule.Name, len(schedule.Participants))}, nil
+}
+
+func handleLeaveSchedule(match allot.MatchInterface, User User) (*genericMessage, error) {
+	name, _ := match.String("schedule")
+	schedule, ok, err := getSchedule(name)
+	if err != nil || !ok {
+		return &genericMessage{ContentText: fmt.Sprintf("I don't know a schedule called %s", name)}, err
+	}
+	info := User.getUserinfo()
+	var participants []OnCallParticipant
+	for _, participant := range schedule.Participants {
+		if !participant.is(info) {
+			participants = append(participants, participant)
+		}
+	}
+	if len(participants) == len(schedule.Participants) {
+		return &genericMessage{ContentText: fmt.Sprintf("You do not take part in %s", schedule.Name)}, nil
+	}
+	schedule.Participants = participants
+	if err := putChatSchedule(schedule); err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not remove you from %s: %s", schedule.Name, err)}, nil
+	}
+	auditUser(User, auditScheduleLeft, schedule.Name, nil)
+	return &genericMessage{ContentText: fmt.Sprintf("%s left the rotation of %s", info.FriendlyName, schedule.Name)}, nil
+}
+
+// handleRouteToOnCall subscribes whoever is on call for a schedule to an alert group
+func handleRouteToOnCall(match allot.MatchInterface, User User) (*genericMessage, error) {
+	alertGroup, _ := match.String("alertgroup")
+	name, _ := match.String("schedule")
+	schedule, ok, err := getSchedule(name)
+	if err != nil || !ok {
+		return &genericMessage{ContentText: fmt.Sprintf("I don't know a schedule called %s", name)}, err
+	}
+	user := onCallUserFor(schedule.Name)
+	if err := user.addToAlertGroup(alertGroup); err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not route %s alerts to %s: %s", alertGroup, schedule.Name, err)}, err
+	}
+	auditUser(User, auditSubscribed, alertGroup, map[string]string{"backend": onCallBackend, "messagePath": schedule.Name})
+	return &genericMessage{ContentText: fmt.Sprintf("Alerts of group %s go to whoever is on call for %s", alertGroup, schedule.Name)}, nil
+}
+
+func onCallUserFor(schedule string) OnCallUser {
+	return OnCallUser{&Userinfo{MessagePath: schedule, Username: schedule, FriendlyName: "on call for " + schedule}}
+}
+
+// sendMessage delivers the message to whoever is on call right now
+func (onCall OnCallUser) sendMessage(msg *genericMessage) error {
+	schedule, ok, err := getSchedule(onCall.MessagePath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("unknown schedule %s", onCall.MessagePath)
+	}
+	shift, err := schedule.shiftAt(time.Now())
+	if err != nil {
+		return err
+	}
+	return shift.user().sendMessage(msg)
+}
+
+func (onCall OnCallUser) addToAlertGroup(group string) error {
+	return subscriptions.Subscribe(Subscription{AlertGroup: group, Backend: onCall.getBackend(), Userinfo: *onCall.Userinfo})
+}
+
+func (onCall OnCallUser) delFromAlertGroup(group string) error {
+	return subscriptions.Unsubscribe(Subscription{AlertGroup: group, Backend: onCall.getBackend(), Userinfo: *onCall.Userinfo})
+}
+
+func (onCall OnCallUser) getUserinfo() *Userinfo {
+	return onCall.Userinfo
+}
+
+func (onCall OnCallUser) getBackend() string {
+	return onCallBackend
+}
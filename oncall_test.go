@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const testSchedule = `
+name: sre
+timeZone: Europe/Berlin
+start: 2019-03-04
+handoff: "09:00"
+participants:
+  - name: Jane
+    messagePath: spaces/jane
+  - name: Joe
+    messagePath: spaces/joe
+overrides:
+  - name: Jane
+    start: 2019-03-20T12:00:00+01:00
+    end: 2019-03-21T12:00:00+01:00
+`
+
+func Test_onCallRotation(t *testing.T) {
+	var schedule OnCallSchedule
+	if err := yaml.Unmarshal([]byte(testSchedule), &schedule); err != nil {
+		t.Fatal(err)
+	}
+	if err := schedule.validate(); err != nil {
+		t.Fatal(err)
+	}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, berlin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	for _, tc := range []struct {
+		at, name, end string
+		override      bool
+	}{
+		{"2019-03-04 09:00", "Jane", "2019-03-11 09:00", false},
+		{"2019-03-11 08:59", "Jane", "2019-03-11 09:00", false},
+		{"2019-03-11 09:00", "Joe", "2019-03-18 09:00", false},
+		{"2019-03-20 13:00", "Jane", "2019-03-21 12:00", true},
+		// Handoff stays at 09:00 local time after the switch to daylight saving time
+		{"2019-04-01 08:30", "Joe", "2019-04-01 09:00", false},
+		{"2019-04-01 09:00", "Jane", "2019-04-08 09:00", false},
+		{"2019-03-01 12:00", "Joe", "2019-03-04 09:00", false},
+	} {
+		shift, err := schedule.shiftAt(at(tc.at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, shift.Name, tc.name, tc.at)
+		assertEqual(t, shift.End.Equal(at(tc.end)), true, tc.at+" ends "+shift.End.String())
+		assertEqual(t, shift.Override, tc.override, tc.at)
+	}
+}
+
+func Test_parseUntil(t *testing.T) {
+	now := time.Date(2019, 3, 4, 20, 0, 0, 0, time.UTC)
+	for text, expected := range map[string]time.Time{
+		"22:00":            time.Date(2019, 3, 4, 22, 0, 0, 0, time.UTC),
+		"08:00":            time.Date(2019, 3, 5, 8, 0, 0, 0, time.UTC),
+		"8h":               now.Add(8 * time.Hour),
+		"2d":               now.AddDate(0, 0, 2),
+		"2019-03-06":       time.Date(2019, 3, 6, 0, 0, 0, 0, time.UTC),
+		"2019-03-06T18:30": time.Date(2019, 3, 6, 18, 30, 0, 0, time.UTC),
+	} {
+		until, err := parseUntil(text, now, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, until.Equal(expected), true, text)
+	}
+	if _, err := parseUntil("tomorrow", now, time.UTC); err == nil {
+		t.Error("Expected tomorrow to be rejected")
+	}
+}
+
+func Test_onCallOverrideCommand(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldConfig := botanistConfig
+	defer func() { botanistConfig = oldConfig }()
+	botanistConfig = &config{}
+
+	// Both give their commands in a shared room
+	jane := HangoutsUser{&Userinfo{MessagePath: "spaces/team", Username: "users/1", FriendlyName: "Jane"}}
+	joe := HangoutsUser{&Userinfo{MessagePath: "spaces/team", Username: "users/2", FriendlyName: "Joe"}}
+	if _, err := handleRequest(&genericMessage{ContentText: "oncall schedule sre 2019-03-04 09:00 UTC", Sender: jane}); err != nil {
+		t.Fatal(err)
+	}
+	response, err := handleRequest(&genericMessage{ContentText: "oncall join sre", Sender: jane})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.Contains(response.ContentText, "direct message"), true, response.ContentText)
+
+	for username, space := range map[string]string{"users/1": "spaces/jane", "users/2": "spaces/joe"} {
+		if err := rememberDMSpace(username, space); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, msg := range []*genericMessage{
+		{ContentText: "oncall join sre", Sender: jane},
+		{ContentText: "oncall join sre", Sender: joe},
+	} {
+		if _, err := handleRequest(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	schedule, ok, err := getSchedule("sre")
+	if err != nil || !ok {
+		t.Fatal("Expected schedule sre to be created", err)
+	}
+	assertEqual(t, len(schedule.Participants), 2, "")
+	assertEqual(t, schedule.Participants[0].MessagePath, "spaces/jane", "")
+	assertEqual(t, schedule.Participants[1].MessagePath, "spaces/joe", "")
+
+	response, err = handleRequest(&genericMessage{ContentText: "oncall override me until 2h", Sender: joe})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.HasPrefix(response.ContentText, "Joe is on call for sre until"), true, response.ContentText)
+	shift, err := schedule.shiftAt(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, shift.Name, "Joe", "")
+	assertEqual(t, shift.Override, true, "")
+
+	response, _ = handleRequest(&genericMessage{ContentText: "who is on call sre", Sender: jane})
+	assertEqual(t, strings.HasPrefix(response.ContentText, "sre: Joe until"), true, response.ContentText)
+
+	recipient, err := recipientFor(onCallBackend, onCallUserFor("sre").Userinfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, recipient.getBackend(), onCallBackend, "")
+
+	// Only Jane leaves although both share the space
+	if _, err := handleRequest(&genericMessage{ContentText: "oncall leave sre", Sender: jane}); err != nil {
+		t.Fatal(err)
+	}
+	schedule, _, _ = getSchedule("sre")
+	assertEqual(t, len(schedule.Participants), 1, "")
+	assertEqual(t, schedule.Participants[0].Name, "Joe", "")
+}
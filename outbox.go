@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// outboxBucket holds all messages that still have to be delivered
+	outboxBucket = []byte("outbox")
+	// outboxScheduleBucket indexes the outbox by the next attempt of each delivery, see scheduleKey
+	outboxScheduleBucket = []byte("outboxSchedule")
+)
+
+// OutboxConfig configures how messages to users are delivered
+type OutboxConfig struct {
+	// Number of concurrent senders, defaults to 4
+	Workers int `yaml:"workers,omitempty"`
+	// Attempts before a message is given up, defaults to 10
+	MaxAttempts int `yaml:"maxAttempts,omitempty"`
+	// Delay before the first retry, doubled for every further attempt. Defaults to 5s
+	InitialBackoff time.Duration `yaml:"initialBackoff,omitempty"`
+	// Upper limit of the delay between retries, defaults to 10m
+	MaxBackoff time.Duration `yaml:"maxBackoff,omitempty"`
+	// Hangouts space (e.g. spaces/XXXXXX) failed deliveries are reported to
+	AdminSpace string `yaml:"adminSpace,omitempty"`
+}
+
+// outboxDelivery is one message to one recipient
+type outboxDelivery struct {
+	ID          uint64          `json:"id"`
+	Backend     string          `json:"backend"`
+	Recipient   *Userinfo       `json:"recipient"`
+	Message     *genericMessage `json:"message"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"nextAttempt"`
+	LastError   string          `json:"lastError,omitempty"`
+}
+
+// outbox delivers the persisted messages with retries
+type outbox struct {
+	wake chan struct{}
+	// closed once the backends can send messages, the workers wait for it
+	ready   <-chan struct{}
+	workers sync.WaitGroup
+
+	mu sync.Mutex
+	// deliveries a worker is currently sending
+	inFlight map[uint64]bool
+}
+
+var messageOutbox = &outbox{
+	wake:     make(chan struct{}, 1),
+	ready:    smsReady,
+	inFlight: make(map[uint64]bool),
+}
+
+// enqueueMessage persists a message for a user and lets the outbox workers deliver it.
+// Without a database the message is sent right away
+func enqueueMessage(user User, msg *genericMessage) error {
+	if stateDB == nil {
+		return user.sendMessage(msg)
+	}
+	err := stateDB.Update(func(tx *bolt.Tx) error {
+		return queueDelivery(tx, user, msg)
+	})
+	if err != nil {
+		return err
+	}
+	messageOutbox.wakeWorker()
+	return nil
+}
+
+// queueDelivery adds a message to the outbox as part of a larger transaction.
+// Call wakeWorker once the transaction is committed
+func queueDelivery(tx *bolt.Tx, user User, msg *genericMessage) error {
+	id, err := tx.Bucket(outboxBucket).NextSequence()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	delivery := &outboxDelivery{
+		ID:          id,
+		Backend:     user.getBackend(),
+		Recipient:   user.getUserinfo(),
+		Message:     msg,
+		CreatedAt:   now,
+		NextAttempt: now,
+	}
+	if err := putDelivery(tx, delivery); err != nil {
+		return err
+	}
+	return logDelivery(tx, delivery, deliveryPending)
+}
+
+// wakeWorker lets a waiting worker pick up newly queued messages
+func (o *outbox) wakeWorker() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// scheduleKey orders deliveries by their next attempt, then by ID
+func scheduleKey(delivery *outboxDelivery) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(delivery.NextAttempt.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], delivery.ID)
+	return key
+}
+
+// unscheduleDelivery removes the stored version of a delivery from the schedule
+func unscheduleDelivery(tx *bolt.Tx, id uint64) error {
+	data := tx.Bucket(outboxBucket).Get(sequenceKey(id))
+	if data == nil {
+		return nil
+	}
+	stored := &outboxDelivery{}
+	if err := json.Unmarshal(data, stored); err != nil {
+		return err
+	}
+	return tx.Bucket(outboxScheduleBucket).Delete(scheduleKey(stored))
+}
+
+// putDelivery stores a new or rescheduled delivery
+func putDelivery(tx *bolt.Tx, delivery *outboxDelivery) error {
+	if err := unscheduleDelivery(tx, delivery.ID); err != nil {
+		return err
+	}
+	data, err := json.Marshal(delivery)
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket(outboxBucket).Put(sequenceKey(delivery.ID), data); err != nil {
+		return err
+	}
+	return tx.Bucket(outboxScheduleBucket).Put(scheduleKey(delivery), nil)
+}
+
+// deleteDelivery takes a delivery out of the outbox
+func deleteDelivery(tx *bolt.Tx, id uint64) error {
+	if err := unscheduleDelivery(tx, id); err != nil {
+		return err
+	}
+	return tx.Bucket(outboxBucket).Delete(sequenceKey(id))
+}
+
+// rebuildSchedule indexes the deliveries of the outbox again, e.g. those queued by a version without the schedule
+func rebuildSchedule() error {
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(outboxScheduleBucket); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		schedule, err := tx.CreateBucket(outboxScheduleBucket)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(outboxBucket).ForEach(func(_, data []byte) error {
+			delivery := &outboxDelivery{}
+			if err := json.Unmarshal(data, delivery); err != nil {
+				return err
+			}
+			return schedule.Put(scheduleKey(delivery), nil)
+		})
+	})
+}
+
+// recipientFor turns a stored recipient back into a User of its backend
+func recipientFor(backend string, info *Userinfo) (User, error) {
+	switch backend {
+	case "hangouts":
+		return HangoutsUser{info}, nil
+	case onCallBackend:
+		return OnCallUser{info}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %q", backend)
+}
+
+// retryDelay tells if a failed delivery should be retried and how long to wait at least
+func retryDelay(err error) (time.Duration, bool) {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		// Network errors and the like
+		return 0, true
+	}
+	if apiErr.Code != http.StatusTooManyRequests && apiErr.Code < 500 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(apiErr.Header.Get("Retry-After")); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, true
+}
+
+func (outboxConfig OutboxConfig) backoff(attempts int) time.Duration {
+	initial, max := outboxConfig.InitialBackoff, outboxConfig.MaxBackoff
+	if initial == 0 {
+		initial = 5 * time.Second
+	}
+	if max == 0 {
+		max = 10 * time.Minute
+	}
+	delay := initial
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// claim returns the next due delivery and marks it in flight.
+// If nothing is due it returns how long to wait for the next one
+func (o *outbox) claim(now time.Time) (*outboxDelivery, time.Duration, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var next *outboxDelivery
+	var wait time.Duration
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		id, nextAttempt, ok := o.firstScheduled(tx)
+		if !ok {
+			wait = time.Minute
+			return nil
+		}
+		if nextAttempt.After(now) {
+			wait = nextAttempt.Sub(now)
+			return nil
+		}
+		next = &outboxDelivery{}
+		return json.Unmarshal(tx.Bucket(outboxBucket).Get(sequenceKey(id)), next)
+	})
+	if err != nil {
+		return nil, time.Minute, err
+	}
+	if next == nil {
+		return nil, wait, nil
+	}
+	o.inFlight[next.ID] = true
+	return next, 0, nil
+}
+
+// firstScheduled returns the ID and next attempt of the earliest delivery that is not in flight.
+// The caller holds o.mu
+func (o *outbox) firstScheduled(tx *bolt.Tx) (uint64, time.Time, bool) {
+	cursor := tx.Bucket(outboxScheduleBucket).Cursor()
+	for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+		if id := binary.BigEndian.Uint64(key[8:]); !o.inFlight[id] {
+			return id, time.Unix(0, int64(binary.BigEndian.Uint64(key))), true
+		}
+	}
+	return 0, time.Time{}, false
+}
+
+func (o *outbox) release(delivery *outboxDelivery) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.inFlight, delivery.ID)
+}
+
+// deliver sends the message and either removes it from the outbox or schedules a retry
+func (o *outbox) deliver(delivery *outboxDelivery) {
+	defer o.release(delivery)
+	deliveryLog := log.WithField("delivery", delivery.ID).WithField("recipient", delivery.Recipient.FriendlyName)
+
+	user, err := recipientFor(delivery.Backend, delivery.Recipient)
+	if err != nil {
+		deliveryLog.Errorf("Dropping undeliverable message: %s", err)
+		outboxDeliveriesFailed.WithLabelValues(delivery.Backend).Inc()
+		delivery.LastError = err.Error()
+		o.remove(delivery, deliveryFailed)
+		return
+	}
+	err = user.sendMessage(delivery.Message)
+	if err == nil {
+		deliveryLog.Debugf("Delivered message after %d attempt(s)", delivery.Attempts+1)
+		delivery.Attempts++
+		o.remove(delivery, deliveryDelivered)
+		return
+	}
+
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+	minDelay, retryable := retryDelay(err)
+	maxAttempts := currentConfig().Outbox.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 10
+	}
+	if !retryable || delivery.Attempts >= maxAttempts {
+		deliveryLog.Errorf("Giving up delivering message after %d attempt(s): %s", delivery.Attempts, err)
+		outboxDeliveriesFailed.WithLabelValues(delivery.Backend).Inc()
+		o.remove(delivery, deliveryFailed)
+		reportFailedDelivery(delivery)
+		return
+	}
+
+	delay := currentConfig().Outbox.backoff(delivery.Attempts)
+	if minDelay > delay {
+		delay = minDelay
+	}
+	delivery.NextAttempt = time.Now().Add(delay)
+	deliveryLog.Warnf("Delivery attempt %d failed, retrying in %s: %s", delivery.Attempts, delay, err)
+	outboxRetries.WithLabelValues(delivery.Backend).Inc()
+	err = stateDB.Update(func(tx *bolt.Tx) error {
+		if err := putDelivery(tx, delivery); err != nil {
+			return err
+		}
+		return logDelivery(tx, delivery, deliveryRetrying)
+	})
+	if err != nil {
+		deliveryLog.Errorf("Failed to reschedule delivery: %s", err)
+	}
+}
+
+// remove takes a delivery out of the outbox and logs its final status
+func (o *outbox) remove(delivery *outboxDelivery, status string) {
+	err := stateDB.Update(func(tx *bolt.Tx) error {
+		if err := deleteDelivery(tx, delivery.ID); err != nil {
+			return err
+		}
+		return logDelivery(tx, delivery, status)
+	})
+	if err != nil {
+		log.Errorf("Failed to remove delivery %d from outbox: %s", delivery.ID, err)
+	}
+}
+
+// reportFailedDelivery tells the admins about a message that could not be delivered
+func reportFailedDelivery(delivery *outboxDelivery) {
+	adminSpace := currentConfig().Outbox.AdminSpace
+	if adminSpace == "" {
+		return
+	}
+	admins := HangoutsUser{&Userinfo{MessagePath: adminSpace, Username: adminSpace, FriendlyName: "admins"}}
+	msg := delivery.Message
+	summary := strings.TrimSpace(strings.Join([]string{msg.HeaderText, msg.FooterText, msg.ContentText}, " "))
+	text := fmt.Sprintf("I could not deliver a message to %s (%s) after %d attempt(s): %s\n%s",
+		delivery.Recipient.FriendlyName, delivery.Recipient.MessagePath, delivery.Attempts, delivery.LastError, summary)
+	if err := admins.sendMessage(&genericMessage{ContentText: text}); err != nil {
+		log.Errorf("Could not report failed delivery to %s: %s", adminSpace, err)
+	}
+}
+
+// worker delivers messages once the backends are ready until the context is cancelled
+func (o *outbox) worker(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		return
+	case <-o.ready:
+	}
+	for {
+		delivery, wait, err := o.claim(time.Now())
+		if err != nil {
+			log.Errorf("Failed to read outbox: %s", err)
+		}
+		if delivery != nil {
+			o.deliver(delivery)
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// pending returns the number of messages waiting for delivery
+func (o *outbox) pending() int {
+	if stateDB == nil {
+		return 0
+	}
+	var count int
+	stateDB.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket(outboxBucket).Stats().KeyN
+		return nil
+	})
+	return count
+}
+
+// startOutbox starts the workers delivering the persisted messages,
+// including those left over from before a restart
+func startOutbox(ctx context.Context) {
+	workers := currentConfig().Outbox.Workers
+	if workers == 0 {
+		workers = 4
+	}
+	if err := rebuildSchedule(); err != nil {
+		log.Errorf("Failed to index the outbox: %s", err)
+	}
+	log.Infof("Starting %d outbox workers, %d message(s) pending", workers, messageOutbox.pending())
+	for i := 0; i < workers; i++ {
+		messageOutbox.workers.Add(1)
+		go func() {
+			defer messageOutbox.workers.Done()
+			messageOutbox.worker(ctx)
+		}()
+	}
+}
+
+// idle tells if no delivery is in flight or due at now
+func (o *outbox) idle(now time.Time) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.inFlight) > 0 {
+		return false
+	}
+	due := false
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		_, nextAttempt, ok := o.firstScheduled(tx)
+		due = ok && !nextAttempt.After(now)
+		return nil
+	})
+	return err == nil && !due
+}
+
+// isReady tells if the backends can send messages
+func (o *outbox) isReady() bool {
+	select {
+	case <-o.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain waits until all due messages are delivered, then stops the workers.
+// Deliveries waiting for a retry stay in the outbox for the next start.
+// Returns an error if ctx expires before the outbox is drained
+func (o *outbox) drain(ctx context.Context, stopWorkers context.CancelFunc) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	// Without ready backends nothing can be delivered, so pending messages are kept for the next start
+	for o.isReady() && !o.idle(time.Now()) {
+		select {
+		case <-ctx.Done():
+			stopWorkers()
+			return fmt.Errorf("outbox not drained, %d message(s) pending", o.pending())
+		case <-ticker.C:
+		}
+	}
+	stopWorkers()
+
+	stopped := make(chan struct{})
+	go func() {
+		o.workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("outbox workers did not stop in time")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"google.golang.org/api/googleapi"
+)
+
+func Test_outboxBackoff(t *testing.T) {
+	outboxConfig := OutboxConfig{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	assertEqual(t, outboxConfig.backoff(1), time.Second, "")
+	assertEqual(t, outboxConfig.backoff(2), 2*time.Second, "")
+	assertEqual(t, outboxConfig.backoff(4), 8*time.Second, "")
+	assertEqual(t, outboxConfig.backoff(5), 10*time.Second, "")
+	assertEqual(t, OutboxConfig{}.backoff(1), 5*time.Second, "")
+}
+
+func Test_retryDelay(t *testing.T) {
+	_, retryable := retryDelay(errors.New("connection refused"))
+	assertEqual(t, retryable, true, "")
+	_, retryable = retryDelay(&googleapi.Error{Code: http.StatusNotFound})
+	assertEqual(t, retryable, false, "")
+	_, retryable = retryDelay(&googleapi.Error{Code: http.StatusBadGateway})
+	assertEqual(t, retryable, true, "")
+	delay, retryable := retryDelay(&googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": []string{"30"}}})
+	assertEqual(t, retryable, true, "")
+	assertEqual(t, delay, 30*time.Second, "")
+}
+
+func Test_outboxClaim(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	user := HangoutsUser{&Userinfo{MessagePath: "spaces/test", Username: "users/1", FriendlyName: "Jane"}}
+	for _, text := range []string{"first", "second"} {
+		if err := enqueueMessage(user, &genericMessage{ContentText: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertEqual(t, messageOutbox.pending(), 2, "")
+
+	first, _, err := messageOutbox.claim(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, first.Message.ContentText, "first", "")
+	assertEqual(t, first.Recipient.FriendlyName, "Jane", "")
+	second, _, err := messageOutbox.claim(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, second.Message.ContentText, "second", "")
+
+	// Both deliveries are in flight - nothing left to claim
+	none, _, err := messageOutbox.claim(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, none == nil, true, "")
+
+	messageOutbox.release(first)
+	messageOutbox.remove(first, deliveryDelivered)
+	messageOutbox.release(second)
+	messageOutbox.remove(second, deliveryDelivered)
+	assertEqual(t, messageOutbox.pending(), 0, "")
+}
+
+func Test_outboxIdle(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	user := HangoutsUser{&Userinfo{MessagePath: "spaces/test", Username: "users/1", FriendlyName: "Jane"}}
+	if err := enqueueMessage(user, &genericMessage{ContentText: "retry me"}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.idle(time.Now()), false, "")
+	// Deliveries waiting for a later retry do not keep the outbox busy
+	assertEqual(t, messageOutbox.idle(time.Now().Add(-time.Hour)), true, "")
+
+	delivery, _, err := messageOutbox.claim(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.idle(time.Now().Add(-time.Hour)), false, "")
+	messageOutbox.release(delivery)
+	messageOutbox.remove(delivery, deliveryDelivered)
+	assertEqual(t, messageOutbox.idle(time.Now()), true, "")
+}
+
+func Test_outboxSchedule(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	user := HangoutsUser{&Userinfo{MessagePath: "spaces/test", Username: "users/1", FriendlyName: "Jane"}}
+	for _, text := range []string{"retried", "due"} {
+		if err := enqueueMessage(user, &genericMessage{ContentText: text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retried, _, err := messageOutbox.claim(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	retried.NextAttempt = time.Now().Add(time.Hour)
+	if err := stateDB.Update(func(tx *bolt.Tx) error { return putDelivery(tx, retried) }); err != nil {
+		t.Fatal(err)
+	}
+	messageOutbox.release(retried)
+
+	// Rebuilding the schedule on startup indexes every delivery once
+	if err := rebuildSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	stateDB.View(func(tx *bolt.Tx) error {
+		assertEqual(t, tx.Bucket(outboxScheduleBucket).Stats().KeyN, 2, "")
+		return nil
+	})
+	due, _, err := messageOutbox.claim(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, due.Message.ContentText, "due", "rescheduled deliveries wait for their next attempt")
+	messageOutbox.release(due)
+	messageOutbox.remove(due, deliveryDelivered)
+
+	none, wait, err := messageOutbox.claim(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, none == nil, true, "")
+	assertEqual(t, wait > 59*time.Minute, true, wait.String())
+	stateDB.View(func(tx *bolt.Tx) error {
+		assertEqual(t, tx.Bucket(outboxScheduleBucket).Stats().KeyN, 1, "")
+		return nil
+	})
+}
+
+func Test_outboxWaitsForBackends(t *testing.T) {
+	defer openTestStateDB(t)()
+
+	user := HangoutsUser{&Userinfo{MessagePath: "spaces/test", Username: "users/1", FriendlyName: "Jane"}}
+	if err := enqueueMessage(user, &genericMessage{ContentText: "early"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := messagesService()
+	assertEqual(t, err != nil, true, "the chat API client is not set up in tests")
+	assertEqual(t, user.sendMessage(&genericMessage{ContentText: "early"}) != nil, true, "sending fails instead of panicking")
+
+	ready := make(chan struct{})
+	o := &outbox{wake: make(chan struct{}, 1), ready: ready, inFlight: make(map[uint64]bool)}
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	o.workers.Add(1)
+	go func() {
+		defer o.workers.Done()
+		o.worker(workerCtx)
+	}()
+	// Nothing can be delivered yet, so draining keeps the pending message for the next start
+	if err := o.drain(context.Background(), stopWorkers); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, o.pending(), 1, "")
+}
@@ -6,17 +6,16 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/prometheus/alertmanager/client"
 	"github.com/prometheus/alertmanager/notify"
 	"github.com/prometheus/alertmanager/template"
-	"github.com/prometheus/alertmanager/types"
-	"github.com/prometheus/client_golang/api"
 	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
 )
 
-const (
-	prometheusListening = ":8081"
-)
+// silenceComment marks silences created by botanist
+const silenceComment = "botanist snooze"
+
+const prometheusIconURL = "https://raw.githubusercontent.com/cncf/artwork/master/prometheus/icon/color/prometheus-icon-color.png"
 
 func promAlertHandler(w http.ResponseWriter, r *http.Request) {
 	var msg notify.WebhookMessage
@@ -38,7 +37,16 @@ func promAlertHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	reqLog.Debugf("Unmarshalled JSON: %#v", msg.Data)
+	notifySubscribers(&msg, "Prometheus alert", prometheusIconURL, reqLog)
+}
+
+// notifySubscribers stores the alerts and sends them to everyone subscribed to the receiver
+func notifySubscribers(msg *notify.WebhookMessage, headerText, pictureURL string, reqLog *logrus.Entry) {
 	reqLog.Debugf("Notification contains %d alert(s)", len(msg.Alerts))
+	alertsReceived.WithLabelValues(receiverLabel(msg.Receiver)).Add(float64(len(msg.Alerts)))
+	if err := recordWebhook(msg); err != nil {
+		reqLog.WithError(err).Error("Failed to store alert history")
+	}
 	commonLabels, _ := json.Marshal(msg.CommonLabels)
 
 	var buttons []*genericButton
@@ -53,63 +61,90 @@ func promAlertHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		buttons = append(buttons, button)
 	}
-	buttons = append(buttons, &genericButton{
-		ContentText:      "Snooze",
-		ButtonText:       "Snooze 1h",
-		CallbackFunction: "prom_silence_1h",
-		CallbackInfos: map[string]string{
-			"labels":          string(commonLabels),
-			"alertMgrAddress": msg.ExternalURL,
-		},
-	})
+	alertKey := escalationKey(msg)
+	if msg.Status == string(model.AlertFiring) {
+		buttons = append(buttons, &genericButton{
+			ContentText:      "Working on it?",
+			ButtonText:       "Acknowledge",
+			CallbackFunction: ackCallback,
+			CallbackInfos: map[string]string{
+				"labels":   string(commonLabels),
+				"receiver": msg.Receiver,
+				"key":      alertKey,
+			},
+		})
+	}
+	// Alerts that did not come through an Alertmanager cannot be silenced
+	if msg.ExternalURL != "" {
+		buttons = append(buttons, &genericButton{
+			ContentText:      "Snooze",
+			ButtonText:       "Snooze 1h",
+			CallbackFunction: "prom_silence_1h",
+			CallbackInfos: map[string]string{
+				"labels":          string(commonLabels),
+				"alertMgrAddress": msg.ExternalURL,
+				"receiver":        msg.Receiver,
+				"key":             alertKey,
+			},
+		})
+	}
 
 	message := &genericMessage{
-		HeaderText:       "Prometheus alert",
+		HeaderText:       headerText,
 		FooterText:       fmt.Sprintf("Alert for group %s", msg.Receiver),
-		HeaderPictureURL: "https://raw.githubusercontent.com/cncf/artwork/master/prometheus/icon/color/prometheus-icon-color.png",
+		HeaderPictureURL: pictureURL,
 		Buttons:          buttons,
 	}
+	if msg.Status == string(model.AlertFiring) {
+		message.AlertKey = alertKey
+	} else if err := forgetSentCards(alertKey); err != nil {
+		reqLog.WithError(err).Error("Failed to forget cards of resolved alerts")
+	}
+	if msg.ExternalURL != "" {
+		message.FooterText += fmt.Sprintf(" from %s", getAlertmanagerConfig(msg.ExternalURL).displayName())
+	}
+	// A group with any critical alert gets through quiet hours
+	critical := false
+	for _, alert := range msg.Alerts {
+		critical = critical || currentConfig().QuietHours.critical(alert.Labels)
+	}
 	hangoutsUser := getHangoutsUsersForAlertGroup(msg.Receiver)
 	for user := range hangoutsUser {
-		user.sendMessage(message)
+		if err := notifyUser(user, message, critical); err != nil {
+			reqLog.WithError(err).Errorf("Failed to queue message for %s", user.getUserinfo().FriendlyName)
+		}
+	}
+	if err := trackEscalation(msg, message); err != nil {
+		reqLog.WithError(err).Error("Failed to track escalation")
+	}
+	if err := trackReminder(msg, message); err != nil {
+		reqLog.WithError(err).Error("Failed to track reminder")
 	}
 }
 
-func startPrometheusListener() {
-	log.Infof("Starting prometheus receiver on %s", prometheusListening)
-
-	http.HandleFunc("/alert", promAlertHandler)
-	log.Fatal(http.ListenAndServe(prometheusListening, nil))
-}
-
-func silenceWithLabels(labels template.KV, username string, alertMgrAddress string) {
-	var matchers types.Matchers
+func silenceWithLabels(labels template.KV, username string, alertMgrAddress string) (string, error) {
+	var matchers []amMatcher
 	for key, value := range labels {
-		match := types.NewMatcher(model.LabelName(key), value)
-		matchers = append(matchers, match)
+		matchers = append(matchers, amMatcher{Name: key, Value: value})
 	}
-	silence := types.Silence{
+	silence := amSilence{
 		Matchers:  matchers,
 		CreatedBy: username,
-		Comment:   "botanist snooze",
-		EndsAt:    time.Now().Add(time.Minute * 5),
-	}
-	err := addSilence(silence, alertMgrAddress)
-	if err != nil {
-		log.Fatalf("Issues when adding silence in alertmanager: %s", err)
+		Comment:   silenceComment,
+		StartsAt:  time.Now(),
+		EndsAt:    time.Now().Add(time.Hour),
 	}
+	return addSilence(silence, alertMgrAddress)
 }
 
-func addSilence(silence types.Silence, alertMgrAddress string) error {
-	apiClient, err := api.NewClient(api.Config{Address: alertMgrAddress})
-	if err != nil {
-		return err
-	}
-	silenceAPI := client.NewSilenceAPI(apiClient)
-	silenceID, err := silenceAPI.Set(ctx, silence)
+func addSilence(silence amSilence, alertMgrAddress string) (string, error) {
+	amConfig := getAlertmanagerConfig(alertMgrAddress)
+	silenceID, err := amConfig.postSilence(ctx, silence)
 	if err != nil {
-		return err
+		silencesFailed.WithLabelValues(amConfig.displayName()).Inc()
+		return "", err
 	}
-	log.Infof("return: %s", silenceID)
-	return nil
+	silencesCreated.WithLabelValues(amConfig.displayName()).Inc()
+	log.Infof("Created silence %s in %s", silenceID, amConfig.displayName())
+	return silenceID, nil
 }
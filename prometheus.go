@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// PrometheusConfig describes how botanist talks to one Prometheus HA pair
+type PrometheusConfig struct {
+	// Name used in chat commands to address this Prometheus
+	Name string `yaml:"name"`
+	// URL of the Prometheus API
+	URL string `yaml:"url"`
+	// URLs of the HA peers. Tried in order if URL does not answer
+	Peers []string `yaml:"peers,omitempty"`
+
+	HTTPClientConfig `yaml:",inline"`
+}
+
+// prometheusQueryResult is the answer of Prometheus' /api/v1/query endpoint
+type prometheusQueryResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// getPrometheusByName returns the configured Prometheus with the given name
+func getPrometheusByName(name string) (PrometheusConfig, bool) {
+	for _, promConfig := range currentConfig().Prometheus {
+		if promConfig.Name == name {
+			return promConfig, true
+		}
+	}
+	return PrometheusConfig{}, false
+}
+
+// query runs an instant query against the first Prometheus that answers
+func (promConfig PrometheusConfig) query(ctx context.Context, expr string) (model.Value, error) {
+	var err error
+	for _, address := range append([]string{promConfig.URL}, promConfig.Peers...) {
+		var client *apiClient
+		client, err = newAPIClient(address, promConfig.HTTPClientConfig)
+		if err != nil {
+			return nil, err
+		}
+		var result prometheusQueryResult
+		err = client.do(ctx, http.MethodGet, "/api/v1/query", url.Values{"query": []string{expr}}, nil, &result)
+		if err == nil {
+			return decodeQueryResult(result)
+		}
+		log.Warnf("Could not query Prometheus %s via %s: %s", promConfig.Name, address, err)
+	}
+	return nil, err
+}
+
+func decodeQueryResult(result prometheusQueryResult) (model.Value, error) {
+	if result.Status != "success" {
+		return nil, fmt.Errorf("query failed: %s", result.Error)
+	}
+	var value model.Value
+	switch result.Data.ResultType {
+	case "vector":
+		value = &model.Vector{}
+	case "matrix":
+		value = &model.Matrix{}
+	case "scalar":
+		value = &model.Scalar{}
+	case "string":
+		value = &model.String{}
+	default:
+		return nil, fmt.Errorf("unknown result type %q", result.Data.ResultType)
+	}
+	if err := json.Unmarshal(result.Data.Result, value); err != nil {
+		return nil, err
+	}
+	// Vector and Matrix are returned as pointers so that
+	// we can unmarshal into them - dereference them again
+	switch v := value.(type) {
+	case *model.Vector:
+		return *v, nil
+	case *model.Matrix:
+		return *v, nil
+	}
+	return value, nil
+}
+
+// formatQueryResult renders a query result as chat text
+func formatQueryResult(value model.Value) string {
+	if vector, ok := value.(model.Vector); ok {
+		if len(vector) == 0 {
+			return "Empty query result"
+		}
+		var text strings.Builder
+		for _, sample := range vector {
+			fmt.Fprintf(&text, "%s => %s\n", sample.Metric, sample.Value)
+		}
+		return text.String()
+	}
+	return value.String()
+}
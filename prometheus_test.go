@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+const testVectorResult = `{"status": "success", "data": {"resultType": "vector", "result": [
+	{"metric": {"__name__": "up", "instance": "host1"}, "value": [1544102857.062, "1"]},
+	{"metric": {"__name__": "up", "instance": "host2"}, "value": [1544102857.062, "0"]}
+]}}`
+
+func decodeTestResult(t *testing.T, body string) (model.Value, error) {
+	var result prometheusQueryResult
+	if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Fatal(err)
+	}
+	return decodeQueryResult(result)
+}
+
+func Test_decodeQueryResult(t *testing.T) {
+	value, err := decodeTestResult(t, testVectorResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vector, ok := value.(model.Vector)
+	assertEqual(t, ok, true, "vectors are returned by value")
+	assertEqual(t, len(vector), 2, "")
+	assertEqual(t, formatQueryResult(vector), "up{instance=\"host1\"} => 1\nup{instance=\"host2\"} => 0\n", "")
+	assertEqual(t, formatQueryResult(model.Vector{}), "Empty query result", "")
+
+	value, err = decodeTestResult(t, `{"status": "success", "data": {"resultType": "scalar", "result": [1544102857.062, "42"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.HasPrefix(formatQueryResult(value), "scalar: 42"), true, formatQueryResult(value))
+
+	_, err = decodeTestResult(t, `{"status": "error", "error": "parse error"}`)
+	assertEqual(t, err != nil && strings.Contains(err.Error(), "parse error"), true, "")
+	_, err = decodeTestResult(t, `{"status": "success", "data": {"resultType": "unknown", "result": []}}`)
+	assertEqual(t, err != nil, true, "unknown result types are rejected")
+}
+
+func Test_prometheusQueryFailover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assertEqual(t, r.URL.Path, "/api/v1/query", "")
+		assertEqual(t, r.URL.Query().Get("query"), "up", "")
+		w.Write([]byte(testVectorResult))
+	}))
+	defer server.Close()
+
+	promConfig := PrometheusConfig{Name: "prom", URL: downServerURL(), Peers: []string{server.URL}}
+	value, err := promConfig.query(context.Background(), "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(value.(model.Vector)), 2, "")
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sbstjn/allot"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// quietBucket holds the quiet hours and do-not-disturb settings per user
+	quietBucket = []byte("quiet")
+	// digestBucket holds the notifications deferred while their recipient is quiet
+	digestBucket = []byte("digests")
+)
+
+// digestCheckInterval is how often deferred notifications are sent to users that are no longer quiet
+const digestCheckInterval = time.Minute
+
+// QuietHoursConfig configures which alerts get through quiet hours and do-not-disturb
+type QuietHoursConfig struct {
+	// Alerts with all of these labels are critical, defaults to severity: critical
+	Critical map[string]string `yaml:"critical,omitempty"`
+}
+
+// critical tells if an alert with these labels is delivered even to quiet users
+func (quietConfig QuietHoursConfig) critical(labels map[string]string) bool {
+	matchers := quietConfig.Critical
+	if len(matchers) == 0 {
+		matchers = map[string]string{"severity": "critical"}
+	}
+	for key, value := range matchers {
+		if labels[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// quietSettings are the times a user does not want to get non-critical notifications
+type quietSettings struct {
+	// Quiet hours as 15:04, To is on the next day if it is before From
+	From     string    `json:"from,omitempty"`
+	To       string    `json:"to,omitempty"`
+	TimeZone string    `json:"timeZone,omitempty"`
+	DNDUntil time.Time `json:"dndUntil,omitempty"`
+}
+
+// deferredNotification is a notification held back for the digest of a quiet user
+type deferredNotification struct {
+	Backend    string          `json:"backend"`
+	Recipient  *Userinfo       `json:"recipient"`
+	Message    *genericMessage `json:"message"`
+	DeferredAt time.Time       `json:"deferredAt"`
+}
+
+func (settings quietSettings) location() (*time.Location, error) {
+	return time.LoadLocation(settings.TimeZone)
+}
+
+// minuteOfDay parses 15:04 into minutes since midnight
+func minuteOfDay(clock string) (int, error) {
+	parsed, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time like 22:00", clock)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// parseQuietHours parses a range like 22:00-07:00
+func parseQuietHours(text string) (string, string, error) {
+	parts := strings.Split(text, "-")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("I could not understand %q, try 22:00-07:00", text)
+	}
+	from, err := minuteOfDay(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	to, err := minuteOfDay(parts[1])
+	if err != nil {
+		return "", "", err
+	}
+	if from == to {
+		return "", "", fmt.Errorf("quiet hours have to start and end at different times")
+	}
+	return parts[0], parts[1], nil
+}
+
+// quiet tells if the user only wants critical notifications at now
+func (settings quietSettings) quiet(now time.Time) bool {
+	if settings.DNDUntil.After(now) {
+		return true
+	}
+	if settings.From == "" || settings.To == "" {
+		return false
+	}
+	loc, err := settings.location()
+	if err != nil {
+		return false
+	}
+	from, errFrom := minuteOfDay(settings.From)
+	to, errTo := minuteOfDay(settings.To)
+	if errFrom != nil || errTo != nil {
+		return false
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if from < to {
+		return minute >= from && minute < to
+	}
+	return minute >= from || minute < to
+}
+
+func (settings quietSettings) String() string {
+	var parts []string
+	if settings.From != "" {
+		parts = append(parts, fmt.Sprintf("Quiet hours are %s-%s %s", settings.From, settings.To, settings.TimeZone))
+	}
+	if settings.DNDUntil.After(time.Now()) {
+		loc, err := settings.location()
+		if err != nil {
+			loc = time.UTC
+		}
+		parts = append(parts, fmt.Sprintf("Do not disturb until %s", settings.DNDUntil.In(loc).Format("Mon Jan 2 15:04 MST")))
+	}
+	if len(parts) == 0 {
+		return "You get all notifications right away"
+	}
+	return strings.Join(parts, "\n")
+}
+
+// quietKey identifies the user and the space the settings belong to. They only apply to
+// notifications in the space they were set in, so other members of a shared space still get them
+func quietKey(info *Userinfo) []byte {
+	return []byte(info.Username + " " + info.MessagePath)
+}
+
+func getQuietSettings(info *Userinfo) (quietSettings, error) {
+	var settings quietSettings
+	if stateDB == nil {
+		return settings, nil
+	}
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		if data := tx.Bucket(quietBucket).Get(quietKey(info)); data != nil {
+			return json.Unmarshal(data, &settings)
+		}
+		return nil
+	})
+	return settings, err
+}
+
+func putQuietSettings(info *Userinfo, settings quietSettings) error {
+	if stateDB == nil {
+		return fmt.Errorf("quiet hours need the database")
+	}
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(quietBucket).Put(quietKey(info), data)
+	})
+}
+
+// notifyUser delivers an alert notification. Non-critical notifications to hangouts users
+// are deferred into a digest while the user is quiet. On-call routing always delivers
+func notifyUser(user User, message *genericMessage, critical bool) error {
+	if stateDB == nil || critical || user.getBackend() != "hangouts" {
+		return enqueueMessage(user, message)
+	}
+	info := user.getUserinfo()
+	settings, err := getQuietSettings(info)
+	if err != nil {
+		log.Warnf("Could not read quiet hours of %s, notifying anyway: %s", info.FriendlyName, err)
+		return enqueueMessage(user, message)
+	}
+	now := time.Now()
+	if !settings.quiet(now) {
+		return enqueueMessage(user, message)
+	}
+	log.Debugf("Deferring notification to %s until they are no longer quiet", info.FriendlyName)
+	notificationsDeferred.Inc()
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(digestBucket)
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(deferredNotification{Backend: user.getBackend(), Recipient: info, Message: message, DeferredAt: now})
+		if err != nil {
+			return err
+		}
+		return bucket.Put(sequenceKey(seq), data)
+	})
+}
+
+// formatDigest summarizes the notifications deferred for one user
+func formatDigest(notifications []deferredNotification) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "%d notification(s) arrived while you were quiet:\n", len(notifications))
+	for _, notification := range notifications {
+		// Text messages like reminders have no header
+		title := notification.Message.HeaderText
+		if title == "" {
+			title = notification.Message.ContentText
+		}
+		fmt.Fprintf(&text, "- %s %s", notification.DeferredAt.Format(time.RFC822), title)
+		if notification.Message.FooterText != "" {
+			fmt.Fprintf(&text, " (%s)", notification.Message.FooterText)
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+// sendDigests sends the notifications deferred for a user in a space once they are no longer quiet there
+func sendDigests(now time.Time) error {
+	deferred := make(map[string][]deferredNotification)
+	keys := make(map[string][][]byte)
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(digestBucket).ForEach(func(key, data []byte) error {
+			var notification deferredNotification
+			if err := json.Unmarshal(data, &notification); err != nil {
+				return err
+			}
+			user := string(quietKey(notification.Recipient))
+			deferred[user] = append(deferred[user], notification)
+			keys[user] = append(keys[user], append([]byte(nil), key...))
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for user, notifications := range deferred {
+		recipient := notifications[0].Recipient
+		settings, err := getQuietSettings(recipient)
+		if err != nil {
+			return err
+		}
+		if settings.quiet(now) {
+			continue
+		}
+		digestUser, err := recipientFor(notifications[0].Backend, recipient)
+		if err != nil {
+			log.Errorf("Dropping digest for %s: %s", recipient.FriendlyName, err)
+		} else {
+			log.Infof("Sending %d deferred notification(s) to %s", len(notifications), recipient.FriendlyName)
+			if err := enqueueMessage(digestUser, &genericMessage{ContentText: formatDigest(notifications)}); err != nil {
+				return err
+			}
+		}
+		err = stateDB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(digestBucket)
+			for _, key := range keys[user] {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startDigestScheduler sends digests once users are no longer quiet until ctx is cancelled
+func startDigestScheduler(ctx context.Context) {
+	runEvery(ctx, digestCheckInterval, func(now time.Time) {
+		if err := sendDigests(now); err != nil {
+			log.Errorf("Failed to send digests: %s", err)
+		}
+	})
+}
+
+// handleQuietHours shows or sets "quiet hours 22:00-07:00 Europe/Berlin". "quiet hours off" disables them
+func handleQuietHours(match allot.MatchInterface, User User) (*genericMessage, error) {
+	info := User.getUserinfo()
+	settings, err := getQuietSettings(info)
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues reading your quiet hours"}, err
+	}
+	hours, err := match.String("hours")
+	if err != nil {
+		return &genericMessage{ContentText: settings.String()}, nil
+	}
+	if hours == "off" {
+		settings.From, settings.To = "", ""
+	} else {
+		settings.From, settings.To, err = parseQuietHours(hours)
+		if err != nil {
+			return &genericMessage{ContentText: err.Error()}, nil
+		}
+		if timeZone, err := match.String("timezone"); err == nil {
+			settings.TimeZone = timeZone
+		} else if settings.TimeZone == "" {
+			settings.TimeZone = "UTC"
+		}
+		if _, err := settings.location(); err != nil {
+			return &genericMessage{ContentText: fmt.Sprintf("I don't know the time zone %s", settings.TimeZone)}, nil
+		}
+	}
+	if err := putQuietSettings(info, settings); err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not store your quiet hours: %s", err)}, err
+	}
+	return &genericMessage{ContentText: settings.String()}, nil
+}
+
+// handleDoNotDisturb answers "dnd for 2h", "dnd until 08:00" and "dnd off"
+func handleDoNotDisturb(match allot.MatchInterface, User User) (*genericMessage, error) {
+	info := User.getUserinfo()
+	settings, err := getQuietSettings(info)
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues reading your do-not-disturb settings"}, err
+	}
+	untilText, err := match.String("until")
+	if err != nil {
+		untilText, err = match.String("duration")
+	}
+	if err != nil {
+		settings.DNDUntil = time.Time{}
+	} else {
+		loc, err := settings.location()
+		if err != nil {
+			loc = time.UTC
+		}
+		now := time.Now()
+		settings.DNDUntil, err = parseUntil(untilText, now, loc)
+		if err != nil {
+			return &genericMessage{ContentText: err.Error()}, nil
+		}
+		if !settings.DNDUntil.After(now) {
+			return &genericMessage{ContentText: fmt.Sprintf("%s is in the past", settings.DNDUntil.In(loc).Format("Mon Jan 2 15:04 MST"))}, nil
+		}
+	}
+	if err := putQuietSettings(info, settings); err != nil {
+		return &genericMessage{ContentText: fmt.Sprintf("I could not store your do-not-disturb settings: %s", err)}, err
+	}
+	return &genericMessage{ContentText: settings.String()}, nil
+}
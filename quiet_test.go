@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_quietHours(t *testing.T) {
+	from, to, err := parseQuietHours("22:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings := quietSettings{From: from, To: to, TimeZone: "Europe/Berlin"}
+	berlin, _ := time.LoadLocation("Europe/Berlin")
+	for clock, expected := range map[string]bool{
+		"21:59": false,
+		"22:00": true,
+		"03:00": true,
+		"06:59": true,
+		"07:00": false,
+		"12:00": false,
+	} {
+		at, _ := time.ParseInLocation("2006-01-02 15:04", "2019-03-04 "+clock, berlin)
+		assertEqual(t, settings.quiet(at), expected, clock)
+	}
+
+	now := time.Date(2019, 3, 4, 12, 0, 0, 0, time.UTC)
+	settings.DNDUntil = now.Add(2 * time.Hour)
+	assertEqual(t, settings.quiet(now), true, "do not disturb")
+	assertEqual(t, settings.quiet(now.Add(3*time.Hour)), false, "do not disturb ended")
+
+	for _, invalid := range []string{"22:00", "22:00-22:00", "25:00-07:00"} {
+		if _, _, err := parseQuietHours(invalid); err == nil {
+			t.Errorf("Expected %s to be rejected", invalid)
+		}
+	}
+	assertEqual(t, QuietHoursConfig{}.critical(map[string]string{"severity": "critical"}), true, "")
+	assertEqual(t, QuietHoursConfig{}.critical(map[string]string{"severity": "warning"}), false, "")
+	assertEqual(t, QuietHoursConfig{Critical: map[string]string{"page": "true"}}.critical(map[string]string{"page": "true"}), true, "")
+}
+
+func Test_quietDigest(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldConfig := botanistConfig
+	defer func() { botanistConfig = oldConfig }()
+	botanistConfig = &config{}
+
+	jane := HangoutsUser{&Userinfo{MessagePath: "spaces/jane", Username: "users/1", FriendlyName: "Jane"}}
+	response, err := handleRequest(&genericMessage{ContentText: "dnd for 2h", Sender: jane})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.HasPrefix(response.ContentText, "Do not disturb until"), true, response.ContentText)
+
+	if err := notifyUser(jane, &genericMessage{HeaderText: "DiskFull", FooterText: "Alert for group wakeup"}, false); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 0, "non-critical notifications are deferred")
+	if err := notifyUser(jane, &genericMessage{HeaderText: "DatacenterOnFire"}, true); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "critical notifications get through")
+
+	if err := sendDigests(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "no digest while quiet")
+
+	if _, err := handleRequest(&genericMessage{ContentText: "dnd off", Sender: jane}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sendDigests(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 2, "digest sent")
+	stateDB.View(func(tx *bolt.Tx) error {
+		assertEqual(t, tx.Bucket(digestBucket).Stats().KeyN, 0, "digest is only sent once")
+		return nil
+	})
+
+	deferredAt := time.Date(2021, 3, 1, 23, 0, 0, 0, time.UTC)
+	digest := formatDigest([]deferredNotification{
+		{Message: &genericMessage{HeaderText: "DiskFull", FooterText: "Alert for group wakeup"}, DeferredAt: deferredAt},
+		{Message: &genericMessage{ContentText: "Reminder: DiskFull has been firing for 1h0m0s"}, DeferredAt: deferredAt},
+	})
+	assertEqual(t, digest, "2 notification(s) arrived while you were quiet:\n"+
+		"- 01 Mar 21 23:00 UTC DiskFull (Alert for group wakeup)\n"+
+		"- 01 Mar 21 23:00 UTC Reminder: DiskFull has been firing for 1h0m0s\n", "text messages show their text")
+
+	response, _ = handleRequest(&genericMessage{ContentText: "quiet hours 22:00-07:00 Europe/Berlin", Sender: jane})
+	assertEqual(t, response.ContentText, "Quiet hours are 22:00-07:00 Europe/Berlin", "")
+	response, _ = handleRequest(&genericMessage{ContentText: "quiet hours off", Sender: jane})
+	assertEqual(t, response.ContentText, "You get all notifications right away", "")
+}
+
+func Test_quietHoursSharedSpace(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldConfig := botanistConfig
+	defer func() { botanistConfig = oldConfig }()
+	botanistConfig = &config{}
+
+	janeDM := HangoutsUser{&Userinfo{MessagePath: "spaces/jane", Username: "users/1", FriendlyName: "Jane"}}
+	janeRoom := HangoutsUser{&Userinfo{MessagePath: "spaces/team", Username: "users/1", FriendlyName: "Jane"}}
+	if _, err := handleRequest(&genericMessage{ContentText: "dnd for 2h", Sender: janeDM}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := notifyUser(janeRoom, &genericMessage{HeaderText: "DiskFull"}, false); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "subscriptions in a shared room are not deferred")
+	if err := notifyUser(janeDM, &genericMessage{HeaderText: "DiskFull"}, false); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "subscriptions in the DM are deferred")
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/alertmanager/notify"
+	"github.com/prometheus/common/model"
+	bolt "go.etcd.io/bbolt"
+)
+
+// reminderBucket holds the firing alert groups botanist reminds about
+var reminderBucket = []byte("reminders")
+
+// reminderCheckInterval is how often due reminders are sent
+const reminderCheckInterval = time.Minute
+
+// ReminderConfig re-posts alerts that keep firing without anybody acknowledging or silencing them.
+// It is independent of Alertmanager's repeat_interval
+type ReminderConfig struct {
+	// Receivers (alertGroups) reminded about, all if empty
+	Receivers []string `yaml:"receivers,omitempty"`
+	// First reminder once the alert group fired this long
+	After time.Duration `yaml:"after"`
+	// Time between further reminders, defaults to after
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// reminder tracks a firing alert group of a receiver with reminders
+type reminder struct {
+	Key          string            `json:"key"`
+	Receiver     string            `json:"receiver"`
+	Labels       map[string]string `json:"labels"`
+	Alertmanager string            `json:"alertmanager,omitempty"`
+	Title        string            `json:"title"`
+	FiringSince  time.Time         `json:"firingSince"`
+	RemindedAt   time.Time         `json:"remindedAt,omitempty"`
+}
+
+func (reminderConfig ReminderConfig) validate() error {
+	if reminderConfig.After <= 0 {
+		return fmt.Errorf("after is required")
+	}
+	if reminderConfig.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	return nil
+}
+
+func (reminderConfig ReminderConfig) interval() time.Duration {
+	if reminderConfig.Interval == 0 {
+		return reminderConfig.After
+	}
+	return reminderConfig.Interval
+}
+
+func (reminderConfig ReminderConfig) appliesTo(receiver string) bool {
+	if len(reminderConfig.Receivers) == 0 {
+		return true
+	}
+	for _, configReceiver := range reminderConfig.Receivers {
+		if configReceiver == receiver {
+			return true
+		}
+	}
+	return false
+}
+
+// reminderConfigFor returns the first reminder config applying to a receiver
+func reminderConfigFor(receiver string) (ReminderConfig, bool) {
+	for _, reminderConfig := range currentConfig().Reminders {
+		if reminderConfig.appliesTo(receiver) {
+			return reminderConfig, true
+		}
+	}
+	return ReminderConfig{}, false
+}
+
+// due returns when the next reminder is to be sent
+func (rem *reminder) due(reminderConfig ReminderConfig) time.Time {
+	if rem.RemindedAt.IsZero() {
+		return rem.FiringSince.Add(reminderConfig.After)
+	}
+	return rem.RemindedAt.Add(reminderConfig.interval())
+}
+
+// trackReminder starts tracking a firing alert group if its receiver has reminders
+// and stops once it resolved. Alert groups that are already tracked keep their reminders
+func trackReminder(msg *notify.WebhookMessage, message *genericMessage) error {
+	if stateDB == nil {
+		return nil
+	}
+	key := escalationKey(msg)
+	if msg.Status == string(model.AlertResolved) {
+		return stateDB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(reminderBucket).Delete([]byte(key))
+		})
+	}
+	if _, ok := reminderConfigFor(msg.Receiver); !ok {
+		return nil
+	}
+	var firingSince time.Time
+	for _, alert := range msg.Alerts {
+		if alert.Status == string(model.AlertFiring) && (firingSince.IsZero() || alert.StartsAt.Before(firingSince)) {
+			firingSince = alert.StartsAt
+		}
+	}
+	if firingSince.IsZero() {
+		firingSince = time.Now()
+	}
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(reminderBucket)
+		if bucket.Get([]byte(key)) != nil {
+			return nil
+		}
+		data, err := json.Marshal(&reminder{
+			Key:          key,
+			Receiver:     msg.Receiver,
+			Labels:       msg.CommonLabels,
+			Alertmanager: msg.ExternalURL,
+			Title:        message.HeaderText,
+			FiringSince:  firingSince,
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), data)
+	})
+}
+
+// handled tells if the alert group resolved or somebody acknowledged or silenced it through botanist
+func (rem *reminder) handled() (bool, error) {
+	records, err := queryHistory(rem.Labels, rem.FiringSince)
+	if err != nil {
+		return false, err
+	}
+	firing := false
+	for _, record := range records {
+		if record.Receiver != rem.Receiver || !record.firing() {
+			continue
+		}
+		firing = true
+		for _, action := range record.Actions {
+			if action.Action == "acknowledged" || action.Action == "silenced" {
+				return true, nil
+			}
+		}
+	}
+	return !firing, nil
+}
+
+func (rem *reminder) message(now time.Time) string {
+	return fmt.Sprintf("Reminder: %s has been firing for %s and nobody acknowledged or silenced it yet",
+		rem.Title, now.Sub(rem.FiringSince).Round(time.Minute))
+}
+
+// remind posts the reminder in the latest thread of the alert cards per space, or to the subscribers
+// if no card is known. Quiet users get it in their digest unless the alert group is critical
+func (rem *reminder) remind(now time.Time) error {
+	cards, err := sentCards(rem.Key)
+	if err != nil {
+		return err
+	}
+	text := rem.message(now)
+	critical := currentConfig().QuietHours.critical(rem.Labels)
+	subscribers := getHangoutsUsersForAlertGroup(rem.Receiver)
+	// Subscribers of a space are reminded there, so their quiet hours apply
+	subscriberIn := make(map[string]User)
+	for user := range subscribers {
+		if user.getBackend() == "hangouts" {
+			subscriberIn[user.getUserinfo().MessagePath] = user
+		}
+	}
+	reminded := make(map[string]bool)
+	// Cards are in the order they were sent, so start with the latest
+	for i := len(cards) - 1; i >= 0; i-- {
+		card := cards[i]
+		if card.Thread == "" || reminded[card.Backend+" "+card.space()] {
+			continue
+		}
+		user, ok := subscriberIn[card.space()]
+		if !ok || card.Backend != "hangouts" {
+			if user, err = recipientFor(card.Backend, &Userinfo{MessagePath: card.space(), FriendlyName: card.space()}); err != nil {
+				log.Errorf("Cannot remind about %s in %s: %s", rem.Key, card.Thread, err)
+				continue
+			}
+		}
+		if err := notifyUser(user, &genericMessage{ContentText: text, Thread: card.Thread}, critical); err != nil {
+			return err
+		}
+		reminded[card.Backend+" "+card.space()] = true
+	}
+	if len(reminded) > 0 {
+		return nil
+	}
+	for user := range subscribers {
+		if err := notifyUser(user, &genericMessage{ContentText: text}, critical); err != nil {
+			log.Errorf("Failed to queue reminder for %s: %s", user.getUserinfo().FriendlyName, err)
+		}
+	}
+	return nil
+}
+
+func deleteReminder(key string) error {
+	return stateDB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(reminderBucket).Delete([]byte(key))
+	})
+}
+
+// remindDue sends all reminders that are due at now
+func remindDue(ctx context.Context, now time.Time) error {
+	var reminders []*reminder
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(reminderBucket).ForEach(func(_, data []byte) error {
+			rem := &reminder{}
+			if err := json.Unmarshal(data, rem); err != nil {
+				return err
+			}
+			reminders = append(reminders, rem)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, rem := range reminders {
+		reminderConfig, ok := reminderConfigFor(rem.Receiver)
+		if !ok {
+			if err := deleteReminder(rem.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		if rem.due(reminderConfig).After(now) {
+			continue
+		}
+		handled, err := rem.handled()
+		if err != nil {
+			return err
+		}
+		if handled {
+			log.Infof("Stopped reminding about %s: resolved, acknowledged or silenced", rem.Key)
+			if err := deleteReminder(rem.Key); err != nil {
+				return err
+			}
+			continue
+		}
+		// Silences made in Alertmanager directly may end, so keep the reminder but skip it for now
+		if suppressed, err := alertsSuppressed(ctx, rem.Alertmanager, rem.Labels); err != nil {
+			log.Warnf("Could not check whether %s is still active, reminding anyway: %s", rem.Key, err)
+		} else if suppressed {
+			continue
+		}
+
+		log.Infof("Reminding about %s of %s, firing since %s", rem.Key, rem.Receiver, rem.FiringSince)
+		remindersSent.WithLabelValues(receiverLabel(rem.Receiver)).Inc()
+		if err := rem.remind(now); err != nil {
+			return err
+		}
+		rem.RemindedAt = now
+		err = stateDB.Update(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket(reminderBucket)
+			// Stop if the alert group resolved in the meantime
+			if bucket.Get([]byte(rem.Key)) == nil {
+				return nil
+			}
+			data, err := json.Marshal(rem)
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte(rem.Key), data)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startReminderScheduler sends due reminders until ctx is cancelled
+func startReminderScheduler(ctx context.Context) {
+	runEvery(ctx, reminderCheckInterval, func(now time.Time) {
+		if err := remindDue(ctx, now); err != nil {
+			log.Errorf("Failed to send reminders: %s", err)
+		}
+	})
+}
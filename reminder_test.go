@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/alertmanager/template"
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_reminders(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldConfig := botanistConfig
+	defer func() { botanistConfig = oldConfig }()
+	botanistConfig = &config{Reminders: []ReminderConfig{{Receivers: []string{"wakeup"}, After: 30 * time.Minute, Interval: time.Hour}}}
+	if err := botanistConfig.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := loadTestWebhook(t)
+	msg.ExternalURL = ""
+	if err := recordWebhook(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := trackReminder(msg, &genericMessage{HeaderText: "WakeupTest"}); err != nil {
+		t.Fatal(err)
+	}
+	// Changed common labels do not start another reminder for the same group
+	joined := loadTestWebhook(t)
+	joined.CommonLabels = template.KV{"alertname": "WakeupTest"}
+	if err := trackReminder(joined, &genericMessage{HeaderText: "WakeupTest"}); err != nil {
+		t.Fatal(err)
+	}
+	stateDB.View(func(tx *bolt.Tx) error {
+		assertEqual(t, tx.Bucket(reminderBucket).Stats().KeyN, 1, "")
+		return nil
+	})
+	alertKey := escalationKey(msg)
+	if err := recordSentCard(alertKey, "hangouts", "spaces/a/messages/1", "spaces/a/threads/1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	firingSince := msg.Alerts[0].StartsAt
+	for _, step := range []struct {
+		after   time.Duration
+		pending int
+	}{
+		{10 * time.Minute, 0},
+		{31 * time.Minute, 1},
+		{40 * time.Minute, 1},
+		{92 * time.Minute, 2},
+	} {
+		if err := remindDue(ctx, firingSince.Add(step.after)); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, messageOutbox.pending(), step.pending, step.after.String())
+	}
+
+	stateDB.View(func(tx *bolt.Tx) error {
+		var delivery outboxDelivery
+		if err := json.Unmarshal(tx.Bucket(outboxBucket).Get(sequenceKey(1)), &delivery); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, delivery.Recipient.MessagePath, "spaces/a", "")
+		assertEqual(t, delivery.Message.Thread, "spaces/a/threads/1", "reminders are posted in the alert's thread")
+		assertEqual(t, strings.Contains(delivery.Message.ContentText, "WakeupTest has been firing for 31m0s"), true, delivery.Message.ContentText)
+		return nil
+	})
+
+	if err := recordAlertAction(msg.Receiver, msg.CommonLabels, "acknowledged", "Jane"); err != nil {
+		t.Fatal(err)
+	}
+	if err := remindDue(ctx, firingSince.Add(3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 2, "acknowledged alerts are not reminded about")
+	stateDB.View(func(tx *bolt.Tx) error {
+		assertEqual(t, tx.Bucket(reminderBucket).Stats().KeyN, 0, "")
+		return nil
+	})
+}
+
+func Test_remindOncePerSpace(t *testing.T) {
+	defer openTestStateDB(t)()
+	oldConfig, oldSubscriptions := botanistConfig, subscriptions
+	defer func() { botanistConfig, subscriptions = oldConfig, oldSubscriptions }()
+	botanistConfig = &config{}
+	subscriptions = newFileSubscriptionStore("")
+
+	jane := HangoutsUser{&Userinfo{MessagePath: "spaces/jane", Username: "users/1", FriendlyName: "Jane"}}
+	if err := subscriptions.Subscribe(Subscription{AlertGroup: "wakeup", Backend: "hangouts", Userinfo: *jane.Userinfo}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handleRequest(&genericMessage{ContentText: "dnd for 2h", Sender: jane}); err != nil {
+		t.Fatal(err)
+	}
+	for _, card := range []struct{ name, thread string }{
+		{"spaces/team/messages/1", "spaces/team/threads/1"},
+		{"spaces/team/messages/2", "spaces/team/threads/2"},
+		{"spaces/jane/messages/3", "spaces/jane/threads/3"},
+	} {
+		if err := recordSentCard("wakeup/alert", "hangouts", card.name, card.thread); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rem := &reminder{Key: "wakeup/alert", Receiver: "wakeup", Labels: map[string]string{"severity": "warning"}, Title: "WakeupTest"}
+	if err := rem.remind(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 1, "one reminder in the team space, Jane's is deferred")
+	stateDB.View(func(tx *bolt.Tx) error {
+		var delivery outboxDelivery
+		if err := json.Unmarshal(tx.Bucket(outboxBucket).Get(sequenceKey(1)), &delivery); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, delivery.Message.Thread, "spaces/team/threads/2", "reminders go to the latest thread")
+		assertEqual(t, tx.Bucket(digestBucket).Stats().KeyN, 1, "")
+		return nil
+	})
+
+	rem.Labels["severity"] = "critical"
+	if err := rem.remind(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, messageOutbox.pending(), 3, "critical reminders get through")
+}
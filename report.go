@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sbstjn/allot"
+)
+
+// ReportConfig configures the scheduled weekly alert report
+type ReportConfig struct {
+	// Alert group whose subscribers receive the weekly report.
+	// No report is sent if this is empty
+	AlertGroup string `yaml:"alertGroup,omitempty"`
+	// Day of the week the report is sent on, defaults to Monday
+	Weekday string `yaml:"weekday,omitempty"`
+	// Time of day (HH:MM) the report is sent at, defaults to 09:00
+	Time string `yaml:"time,omitempty"`
+	// How many of the noisiest alerts to list, defaults to 10
+	TopAlerts int `yaml:"topAlerts,omitempty"`
+}
+
+// alertCount is how often an alert fired
+type alertCount struct {
+	Name  string
+	Count int
+}
+
+// alertReport summarizes the alert history of a period
+type alertReport struct {
+	Since, Until time.Time
+	Occurrences  int
+	Noisiest     []alertCount
+	// Alerts that were silenced or acknowledged and how long that took on average
+	Reacted           int
+	MeanTimeToReact   time.Duration
+	Resolved          int
+	MeanTimeToResolve time.Duration
+	// Alerts nobody did anything about
+	Unattended []alertCount
+}
+
+func buildReport(records []*alertRecord, since, until time.Time, top int) alertReport {
+	report := alertReport{Since: since, Until: until}
+	fired := make(map[string]int)
+	unattended := make(map[string]int)
+	var timeToReact, timeToResolve time.Duration
+
+	for _, record := range records {
+		if record.StartsAt.After(until) {
+			continue
+		}
+		name := record.Labels["alertname"]
+		report.Occurrences++
+		fired[name]++
+		if len(record.Actions) > 0 {
+			report.Reacted++
+			timeToReact += record.Actions[0].At.Sub(record.StartsAt)
+		} else {
+			unattended[name]++
+		}
+		if !record.firing() {
+			report.Resolved++
+			timeToResolve += record.duration(until)
+		}
+	}
+	if report.Reacted > 0 {
+		report.MeanTimeToReact = timeToReact / time.Duration(report.Reacted)
+	}
+	if report.Resolved > 0 {
+		report.MeanTimeToResolve = timeToResolve / time.Duration(report.Resolved)
+	}
+	report.Noisiest = topAlertCounts(fired, top)
+	report.Unattended = topAlertCounts(unattended, 0)
+	return report
+}
+
+// topAlertCounts sorts the counts descending and returns the first top ones (all if top is 0)
+func topAlertCounts(counts map[string]int, top int) []alertCount {
+	var sorted []alertCount
+	for name, count := range counts {
+		sorted = append(sorted, alertCount{Name: name, Count: count})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count == sorted[j].Count {
+			return sorted[i].Name < sorted[j].Name
+		}
+		return sorted[i].Count > sorted[j].Count
+	})
+	if top > 0 && len(sorted) > top {
+		sorted = sorted[:top]
+	}
+	return sorted
+}
+
+func (report alertReport) String() string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "Alert report %s - %s\n", report.Since.Format(time.RFC822), report.Until.Format(time.RFC822))
+	if report.Occurrences == 0 {
+		text.WriteString("No alerts fired in this period")
+		return text.String()
+	}
+	fmt.Fprintf(&text, "%d alert(s) fired\n", report.Occurrences)
+	text.WriteString("\nNoisiest alerts:\n")
+	for _, alert := range report.Noisiest {
+		fmt.Fprintf(&text, "- %s: %d\n", alert.Name, alert.Count)
+	}
+	fmt.Fprintf(&text, "\nAverage time to acknowledge/silence: %s (%d alerts)\n", report.MeanTimeToReact.Round(time.Second), report.Reacted)
+	fmt.Fprintf(&text, "Average time to resolve: %s (%d alerts)\n", report.MeanTimeToResolve.Round(time.Second), report.Resolved)
+	if len(report.Unattended) > 0 {
+		text.WriteString("\nAlerts nobody reacted to:\n")
+		for _, alert := range report.Unattended {
+			fmt.Fprintf(&text, "- %s: %d\n", alert.Name, alert.Count)
+		}
+	}
+	return text.String()
+}
+
+func generateReport(period time.Duration, until time.Time) (alertReport, error) {
+	since := until.Add(-period)
+	records, err := queryHistory(nil, since)
+	if err != nil {
+		return alertReport{}, err
+	}
+	top := currentConfig().Report.TopAlerts
+	if top == 0 {
+		top = 10
+	}
+	return buildReport(records, since, until, top), nil
+}
+
+func handleReport(match allot.MatchInterface, User User) (*genericMessage, error) {
+	period := 7 * 24 * time.Hour
+	if periodName, err := match.String("period"); err == nil {
+		switch periodName {
+		case "week":
+		case "month":
+			period = 30 * 24 * time.Hour
+		default:
+			return &genericMessage{ContentText: "I can only report about a week or a month"}, nil
+		}
+	}
+	report, err := generateReport(period, time.Now())
+	if err != nil {
+		return &genericMessage{ContentText: "I had issues reading the alert history"}, err
+	}
+	return &genericMessage{ContentText: report.String()}, nil
+}
+
+// nextReportTime returns when the next weekly report is due after now
+func nextReportTime(reportConfig ReportConfig, now time.Time) (time.Time, error) {
+	weekday := time.Monday
+	if reportConfig.Weekday != "" {
+		found := false
+		for day := time.Sunday; day <= time.Saturday; day++ {
+			if strings.EqualFold(day.String(), reportConfig.Weekday) {
+				weekday, found = day, true
+			}
+		}
+		if !found {
+			return time.Time{}, fmt.Errorf("unknown weekday %q", reportConfig.Weekday)
+		}
+	}
+	timeOfDay := reportConfig.Time
+	if timeOfDay == "" {
+		timeOfDay = "09:00"
+	}
+	clock, err := time.Parse("15:04", timeOfDay)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid report time %q", timeOfDay)
+	}
+
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	next = next.AddDate(0, 0, (int(weekday)-int(next.Weekday())+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next, nil
+}
+
+// startReportScheduler sends the weekly report to the configured alert group.
+// The schedule follows configuration reloads until ctx is cancelled
+func startReportScheduler(ctx context.Context) {
+	reloaded := notifyConfigReload()
+	for {
+		reportConfig := currentConfig().Report
+		if reportConfig.AlertGroup == "" {
+			if !waitForReload(ctx, reloaded) {
+				return
+			}
+			continue
+		}
+		next, err := nextReportTime(reportConfig, time.Now())
+		if err != nil {
+			log.Errorf("Not sending weekly reports: %s", err)
+			if !waitForReload(ctx, reloaded) {
+				return
+			}
+			continue
+		}
+		log.Infof("Next weekly report will be sent at %s", next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-reloaded:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+
+		report, err := generateReport(7*24*time.Hour, time.Now())
+		if err != nil {
+			log.Errorf("Could not generate weekly report: %s", err)
+			continue
+		}
+		message := &genericMessage{ContentText: report.String()}
+		for user := range getHangoutsUsersForAlertGroup(reportConfig.AlertGroup) {
+			if err := enqueueMessage(user, message); err != nil {
+				log.Errorf("Could not queue weekly report for %s: %s", user.getUserinfo().FriendlyName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_nextReportTime(t *testing.T) {
+	// 2019-02-13 is a Wednesday
+	now := time.Date(2019, 2, 13, 10, 0, 0, 0, time.UTC)
+
+	next, err := nextReportTime(ReportConfig{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, next, time.Date(2019, 2, 18, 9, 0, 0, 0, time.UTC), "")
+
+	next, err = nextReportTime(ReportConfig{Weekday: "wednesday", Time: "11:30"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, next, time.Date(2019, 2, 13, 11, 30, 0, 0, time.UTC), "")
+
+	next, err = nextReportTime(ReportConfig{Weekday: "Wednesday", Time: "09:00"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, next, time.Date(2019, 2, 20, 9, 0, 0, 0, time.UTC), "")
+
+	_, err = nextReportTime(ReportConfig{Weekday: "Someday"}, now)
+	assertEqual(t, err != nil, true, "")
+}
+
+func Test_buildReport(t *testing.T) {
+	start := time.Date(2019, 2, 13, 10, 0, 0, 0, time.UTC)
+	records := []*alertRecord{
+		{Labels: map[string]string{"alertname": "A"}, StartsAt: start, EndsAt: start.Add(time.Hour),
+			Actions: []alertAction{{Action: "silenced", At: start.Add(10 * time.Minute)}}},
+		{Labels: map[string]string{"alertname": "A"}, StartsAt: start, EndsAt: start.Add(3 * time.Hour)},
+		{Labels: map[string]string{"alertname": "B"}, StartsAt: start},
+	}
+	report := buildReport(records, start.Add(-time.Hour), start.Add(5*time.Hour), 1)
+	assertEqual(t, report.Occurrences, 3, "")
+	assertEqual(t, len(report.Noisiest), 1, "")
+	assertEqual(t, report.Noisiest[0], alertCount{Name: "A", Count: 2}, "")
+	assertEqual(t, report.MeanTimeToReact, 10*time.Minute, "")
+	assertEqual(t, report.MeanTimeToResolve, 2*time.Hour, "")
+	assertEqual(t, len(report.Unattended), 2, "")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// role grants the permissions of all roles below it
+type role int
+
+const (
+	roleNone role = iota
+	roleViewer
+	roleResponder
+	roleAdmin
+)
+
+var roleNames = map[role]string{
+	roleNone:      "none",
+	roleViewer:    "viewer",
+	roleResponder: "responder",
+	roleAdmin:     "admin",
+}
+
+func (r role) String() string {
+	return roleNames[r]
+}
+
+func parseRole(name string) (role, error) {
+	for r, roleName := range roleNames {
+		if strings.EqualFold(name, roleName) {
+			return r, nil
+		}
+	}
+	return roleNone, fmt.Errorf("unknown role %q", name)
+}
+
+// PermissionsConfig assigns roles to chat users.
+// Without any assignments everybody is an admin
+type PermissionsConfig struct {
+	// Role of users without an assignment, defaults to viewer
+	DefaultRole string `yaml:"defaultRole,omitempty"`
+	// Named lists of users (user names like users/123456 or email addresses)
+	Groups map[string][]string `yaml:"groups,omitempty"`
+	Roles  []RoleAssignment    `yaml:"roles,omitempty"`
+}
+
+// RoleAssignment gives a role to users, groups of users and everybody with an email address in a domain
+type RoleAssignment struct {
+	Role    string   `yaml:"role"`
+	Users   []string `yaml:"users,omitempty"`
+	Groups  []string `yaml:"groups,omitempty"`
+	Domains []string `yaml:"domains,omitempty"`
+}
+
+// commandRoles is the role needed to run a command.
+// Commands missing here are only available to admins
+var commandRoles = map[string]role{
+	"echo (.*)":             roleViewer,
+	"welcome <user:string>": roleViewer,
+	"annoy me about <alertgroup:string> alerts":     roleResponder,
+	"don't bug me about <alertgroup:string> alerts": roleResponder,
+	"alerts":                                  roleViewer,
+	"alerts <instance:string>":                roleViewer,
+	"query <instance:string> (.*)":            roleViewer,
+	"history <alert:string>":                  roleViewer,
+	"history <alert:string> <since:string>":   roleViewer,
+	"report":                                  roleViewer,
+	"report <period:string>":                  roleViewer,
+	"who is on call":                          roleViewer,
+	"who is on call <schedule:string>":        roleViewer,
+	"oncall override me until <until:string>": roleResponder,
+	"oncall override me on <schedule:string> until <until:string>": roleResponder,
+	"oncall join <schedule:string>":                                roleResponder,
+	"oncall leave <schedule:string>":                               roleResponder,
+	"quiet hours":                                                  roleViewer,
+	"quiet hours <hours:string>":                                   roleViewer,
+	"quiet hours <hours:string> <timezone:string>":                 roleViewer,
+	"dnd for <duration:string>":                                    roleViewer,
+	"dnd until <until:string>":                                     roleViewer,
+	"dnd off":                                                      roleViewer,
+}
+
+// callbackRoles is the role needed to click a button.
+// Callbacks missing here are only available to admins
+var callbackRoles = map[string]role{
+	"prom_silence_1h": roleResponder,
+	ackCallback:       roleResponder,
+}
+
+func (permissions PermissionsConfig) validate() error {
+	if _, err := permissions.defaultRole(); err != nil {
+		return err
+	}
+	for _, assignment := range permissions.Roles {
+		if _, err := parseRole(assignment.Role); err != nil {
+			return err
+		}
+		for _, group := range assignment.Groups {
+			if _, ok := permissions.Groups[group]; !ok {
+				return fmt.Errorf("unknown group %q in %s role", group, assignment.Role)
+			}
+		}
+	}
+	return nil
+}
+
+func (permissions PermissionsConfig) defaultRole() (role, error) {
+	if permissions.DefaultRole == "" {
+		return roleViewer, nil
+	}
+	return parseRole(permissions.DefaultRole)
+}
+
+// matchesUser tells if an entry of a users list names this user
+func matchesUser(entry string, info *Userinfo) bool {
+	return strings.EqualFold(entry, info.Username) || (info.Email != "" && strings.EqualFold(entry, info.Email))
+}
+
+// roleOf returns the highest role assigned to a user
+func (permissions PermissionsConfig) roleOf(info *Userinfo) role {
+	if len(permissions.Roles) == 0 {
+		return roleAdmin
+	}
+	userRole, err := permissions.defaultRole()
+	if err != nil {
+		userRole = roleNone
+	}
+	for _, assignment := range permissions.Roles {
+		assigned, err := parseRole(assignment.Role)
+		if err != nil || assigned <= userRole {
+			continue
+		}
+		if permissions.assigns(assignment, info) {
+			userRole = assigned
+		}
+	}
+	return userRole
+}
+
+func (permissions PermissionsConfig) assigns(assignment RoleAssignment, info *Userinfo) bool {
+	for _, user := range assignment.Users {
+		if matchesUser(user, info) {
+			return true
+		}
+	}
+	for _, group := range assignment.Groups {
+		for _, member := range permissions.Groups[group] {
+			if matchesUser(member, info) {
+				return true
+			}
+		}
+	}
+	for _, domain := range assignment.Domains {
+		if strings.HasSuffix(strings.ToLower(info.Email), "@"+strings.ToLower(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// authorize returns an error explaining the denial if the user does not have the required role
+func authorize(info *Userinfo, action string, required role) error {
+	userRole := currentConfig().Permissions.roleOf(info)
+	if userRole >= required {
+		return nil
+	}
+	permissionDenials.WithLabelValues(action).Inc()
+	log.WithField("user", info.Username).WithField("email", info.Email).
+		Warnf("Denied %s to %s: needs role %s, has %s", action, info.FriendlyName, required, userRole)
+	return fmt.Errorf("Sorry %s, you are not allowed to do that. It needs the %s role and you are a %s", info.FriendlyName, required, userRole)
+}
+
+// requiredRole returns the role needed for a command or callback, admin if unknown
+func requiredRole(roles map[string]role, action string) role {
+	if required, ok := roles[action]; ok {
+		return required
+	}
+	return roleAdmin
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func Test_roleOf(t *testing.T) {
+	permissions := PermissionsConfig{
+		Groups: map[string][]string{"sre": {"Alice@example.com", "users/42"}},
+		Roles: []RoleAssignment{
+			{Role: "admin", Groups: []string{"sre"}},
+			{Role: "responder", Domains: []string{"example.com"}},
+		},
+	}
+	if err := permissions.validate(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, permissions.roleOf(&Userinfo{Username: "users/1", Email: "alice@example.com"}), roleAdmin, "group member by email")
+	assertEqual(t, permissions.roleOf(&Userinfo{Username: "users/42"}), roleAdmin, "group member by user name")
+	assertEqual(t, permissions.roleOf(&Userinfo{Username: "users/2", Email: "bob@example.com"}), roleResponder, "domain")
+	assertEqual(t, permissions.roleOf(&Userinfo{Username: "users/3", Email: "eve@example.com.evil"}), roleViewer, "default role")
+	assertEqual(t, PermissionsConfig{}.roleOf(&Userinfo{Username: "users/3"}), roleAdmin, "no permissions configured")
+
+	permissions.Roles = append(permissions.Roles, RoleAssignment{Role: "owner"})
+	if err := permissions.validate(); err == nil {
+		t.Error("Expected unknown role to be rejected")
+	}
+}
+
+func Test_handleRequestDenied(t *testing.T) {
+	oldConfig := botanistConfig
+	defer func() { botanistConfig = oldConfig }()
+	botanistConfig.Permissions = PermissionsConfig{
+		DefaultRole: "none",
+		Roles:       []RoleAssignment{{Role: "viewer", Users: []string{"users/1"}}},
+	}
+
+	viewer := HangoutsUser{&Userinfo{Username: "users/1", FriendlyName: "Viewer", MessagePath: "spaces/a"}}
+	response, err := handleRequest(&genericMessage{ContentText: "echo hello", Sender: viewer})
+	assertEqual(t, err, nil, "")
+	assertEqual(t, response.ContentText, "What you said: \"hello\"", "viewers may echo")
+
+	response, err = handleRequest(&genericMessage{ContentText: "annoy me about critical alerts", Sender: viewer, MessagePath: "spaces/a"})
+	assertEqual(t, err, nil, "")
+	assertEqual(t, response.ContentText, "Sorry Viewer, you are not allowed to do that. It needs the responder role and you are a viewer", "")
+	assertEqual(t, response.MessagePath, "spaces/a", "")
+
+	stranger := HangoutsUser{&Userinfo{Username: "users/2", FriendlyName: "Stranger"}}
+	response, _ = handleRequest(&genericMessage{ContentText: "echo hello", Sender: stranger})
+	assertEqual(t, response.ContentText, "Sorry Stranger, you are not allowed to do that. It needs the viewer role and you are a none", "")
+}
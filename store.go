@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// stateDB is botanist's embedded database.
+// It is nil if no database could be opened, e.g. in tests
+var stateDB *bolt.DB
+
+// stateBuckets are created when the database is opened
+var stateBuckets = [][]byte{
+	alertBucket,
+	metaBucket,
+	outboxBucket,
+	outboxScheduleBucket,
+	subscriptionBucket,
+	deliveryLogBucket,
+	auditBucket,
+	escalationBucket,
+	cardBucket,
+	scheduleBucket,
+	overrideBucket,
+	quietBucket,
+	digestBucket,
+	reminderBucket,
+	dmSpaceBucket,
+}
+
+func openStateDB(path string) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range stateBuckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		for _, bucket := range obsoleteBuckets {
+			if err := tx.DeleteBucket(bucket); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return err
+	}
+	stateDB = db
+	return nil
+}
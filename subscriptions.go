@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// subscriptionBucket holds one Subscription per alertGroup/backend/messagePath
+var subscriptionBucket = []byte("subscriptions")
+
+// SubscriptionStoreConfig selects where subscriptions to alert groups are kept
+type SubscriptionStoreConfig struct {
+	// One of bolt (the default, kept in botanist's database), file or sql
+	Type string `yaml:"type,omitempty"`
+	// Path of the state file used by the file store
+	Path string `yaml:"path,omitempty"`
+	// database/sql driver and data source used by the sql store
+	Driver     string `yaml:"driver,omitempty"`
+	DataSource string `yaml:"dataSource,omitempty"`
+}
+
+// Subscription of a user to an alert group
+type Subscription struct {
+	AlertGroup string `json:"alertGroup"`
+	Backend    string `json:"backend"`
+	Userinfo
+}
+
+// SubscriptionStore keeps track of who wants to be notified about which alert group.
+// All implementations are safe for concurrent use and apply every change atomically
+type SubscriptionStore interface {
+	Subscribe(sub Subscription) error
+	Unsubscribe(sub Subscription) error
+	// Subscribers returns the subscriptions of a single alert group
+	Subscribers(alertGroup string) ([]Subscription, error)
+	// All returns the subscriptions of all alert groups
+	All() ([]Subscription, error)
+	Close() error
+}
+
+// subscriptions is where botanist keeps its subscriptions.
+// It is replaced by the configured store on startup
+var subscriptions SubscriptionStore = newFileSubscriptionStore("")
+
+func newSubscriptionStore(storeConfig SubscriptionStoreConfig) (SubscriptionStore, error) {
+	switch storeConfig.Type {
+	case "", "bolt":
+		if stateDB == nil {
+			return nil, fmt.Errorf("bolt subscription store needs the database")
+		}
+		return &boltSubscriptionStore{db: stateDB}, nil
+	case "file":
+		if storeConfig.Path == "" {
+			return nil, fmt.Errorf("file subscription store needs a path")
+		}
+		store := newFileSubscriptionStore(storeConfig.Path)
+		return store, store.load()
+	case "sql":
+		return newSQLSubscriptionStore(storeConfig.Driver, storeConfig.DataSource)
+	}
+	return nil, fmt.Errorf("unknown subscription store type %q", storeConfig.Type)
+}
+
+// key identifies a subscription within a store
+func (sub Subscription) key() string {
+	return strings.Join([]string{sub.AlertGroup, sub.Backend, sub.MessagePath}, "/")
+}
+
+// user returns the subscribed user of its backend
+func (sub Subscription) user() (User, error) {
+	info := sub.Userinfo
+	return recipientFor(sub.Backend, &info)
+}
+
+func sortSubscriptions(subs []Subscription) []Subscription {
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].key() < subs[j].key()
+	})
+	return subs
+}
+
+// subscriptionsMigratedKey marks in the metaBucket that the config file subscriptions were migrated
+var subscriptionsMigratedKey = []byte("subscriptionsMigrated")
+
+// migrateConfigSubscriptions moves subscriptions kept in the config file by
+// earlier versions of botanist into the subscription store. This only happens once,
+// so users who unsubscribed later do not come back after a restart
+func migrateConfigSubscriptions(store SubscriptionStore, oldSubscribers map[string]map[string]HangoutsUser) error {
+	if len(oldSubscribers) == 0 {
+		return nil
+	}
+	if stateDB == nil {
+		return fmt.Errorf("migrating subscriptions needs the database")
+	}
+	var migrated bool
+	err := stateDB.View(func(tx *bolt.Tx) error {
+		migrated = tx.Bucket(metaBucket).Get(subscriptionsMigratedKey) != nil
+		return nil
+	})
+	if err != nil || migrated {
+		return err
+	}
+	markMigrated := func() error {
+		return stateDB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(metaBucket).Put(subscriptionsMigratedKey, []byte(time.Now().Format(time.RFC3339)))
+		})
+	}
+	// Stores of versions before the marker was introduced were already migrated if they have subscriptions
+	existing, err := store.All()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return markMigrated()
+	}
+	for group, users := range oldSubscribers {
+		for _, user := range users {
+			if user.Userinfo == nil {
+				continue
+			}
+			err := store.Subscribe(Subscription{AlertGroup: group, Backend: user.getBackend(), Userinfo: *user.Userinfo})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	log.Infof("Migrated subscriptions of %d alert group(s) from the config file", len(oldSubscribers))
+	return markMigrated()
+}
+
+// fileSubscriptionStore keeps subscriptions in memory and writes them to a JSON state file.
+// Without a path nothing is persisted
+type fileSubscriptionStore struct {
+	path string
+
+	mu   sync.RWMutex
+	subs map[string]Subscription
+}
+
+func newFileSubscriptionStore(path string) *fileSubscriptionStore {
+	return &fileSubscriptionStore{path: path, subs: make(map[string]Subscription)}
+}
+
+func (store *fileSubscriptionStore) load() error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	data, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var subs []Subscription
+	if err := json.Unmarshal(data, &subs); err != nil {
+		return fmt.Errorf("invalid subscription state file %s: %s", store.path, err)
+	}
+	for _, sub := range subs {
+		store.subs[sub.key()] = sub
+	}
+	return nil
+}
+
+// persist atomically replaces the state file with the given subscriptions
+func (store *fileSubscriptionStore) persist(subs map[string]Subscription) error {
+	if store.path == "" {
+		return nil
+	}
+	list := make([]Subscription, 0, len(subs))
+	for _, sub := range subs {
+		list = append(list, sub)
+	}
+	data, err := json.MarshalIndent(sortSubscriptions(list), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(store.path), filepath.Base(store.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), store.path)
+}
+
+// update applies a change to a copy of the subscriptions and only keeps it once it is persisted
+func (store *fileSubscriptionStore) update(change func(subs map[string]Subscription)) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	updated := make(map[string]Subscription, len(store.subs)+1)
+	for key, sub := range store.subs {
+		updated[key] = sub
+	}
+	change(updated)
+	if err := store.persist(updated); err != nil {
+		return err
+	}
+	store.subs = updated
+	return nil
+}
+
+func (store *fileSubscriptionStore) Subscribe(sub Subscription) error {
+	return store.update(func(subs map[string]Subscription) {
+		subs[sub.key()] = sub
+	})
+}
+
+func (store *fileSubscriptionStore) Unsubscribe(sub Subscription) error {
+	return store.update(func(subs map[string]Subscription) {
+		delete(subs, sub.key())
+	})
+}
+
+func (store *fileSubscriptionStore) Subscribers(alertGroup string) ([]Subscription, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	var subs []Subscription
+	for _, sub := range store.subs {
+		if sub.AlertGroup == alertGroup {
+			subs = append(subs, sub)
+		}
+	}
+	return sortSubscriptions(subs), nil
+}
+
+func (store *fileSubscriptionStore) All() ([]Subscription, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	subs := make([]Subscription, 0, len(store.subs))
+	for _, sub := range store.subs {
+		subs = append(subs, sub)
+	}
+	return sortSubscriptions(subs), nil
+}
+
+func (store *fileSubscriptionStore) Close() error {
+	return nil
+}
+
+// boltSubscriptionStore keeps subscriptions in botanist's database
+type boltSubscriptionStore struct {
+	db *bolt.DB
+}
+
+func (store *boltSubscriptionStore) Subscribe(sub Subscription) error {
+	data, err := json.Marshal(sub)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionBucket).Put([]byte(sub.key()), data)
+	})
+}
+
+func (store *boltSubscriptionStore) Unsubscribe(sub Subscription) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionBucket).Delete([]byte(sub.key()))
+	})
+}
+
+// list returns all subscriptions accepted by filter, ordered by their key
+func (store *boltSubscriptionStore) list(filter func(Subscription) bool) ([]Subscription, error) {
+	var subs []Subscription
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(subscriptionBucket).ForEach(func(_, data []byte) error {
+			var sub Subscription
+			if err := json.Unmarshal(data, &sub); err != nil {
+				return err
+			}
+			if filter(sub) {
+				subs = append(subs, sub)
+			}
+			return nil
+		})
+	})
+	return subs, err
+}
+
+func (store *boltSubscriptionStore) Subscribers(alertGroup string) ([]Subscription, error) {
+	return store.list(func(sub Subscription) bool { return sub.AlertGroup == alertGroup })
+}
+
+func (store *boltSubscriptionStore) All() ([]Subscription, error) {
+	return store.list(func(Subscription) bool { return true })
+}
+
+func (store *boltSubscriptionStore) Close() error {
+	// The database is shared and closed by main
+	return nil
+}
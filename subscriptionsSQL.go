@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	// PostgreSQL driver for the sql subscription store
+	_ "github.com/lib/pq"
+)
+
+const sqlSubscriptionSchema = `CREATE TABLE IF NOT EXISTS subscriptions (
+	alert_group   VARCHAR(255) NOT NULL,
+	backend       VARCHAR(64)  NOT NULL,
+	message_path  VARCHAR(255) NOT NULL,
+	username      VARCHAR(255) NOT NULL,
+	friendly_name VARCHAR(255) NOT NULL,
+	email         VARCHAR(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (alert_group, backend, message_path)
+)`
+
+// sqlSubscriptionMigrations update tables created by earlier versions
+var sqlSubscriptionMigrations = []string{
+	`ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS email VARCHAR(255) NOT NULL DEFAULT ''`,
+}
+
+// sqlSubscriptionStore keeps subscriptions in an SQL database
+type sqlSubscriptionStore struct {
+	db     *sql.DB
+	driver string
+}
+
+func newSQLSubscriptionStore(driver, dataSource string) (*sqlSubscriptionStore, error) {
+	if driver == "" {
+		driver = "postgres"
+	}
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqlSubscriptionSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create subscriptions table: %s", err)
+	}
+	for _, migration := range sqlSubscriptionMigrations {
+		if _, err := db.Exec(migration); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("unable to update subscriptions table: %s", err)
+		}
+	}
+	return &sqlSubscriptionStore{db: db, driver: driver}, nil
+}
+
+// rebind replaces ? placeholders with $1, $2, ... for drivers that need it
+func (store *sqlSubscriptionStore) rebind(query string) string {
+	if store.driver != "postgres" {
+		return query
+	}
+	var rebound strings.Builder
+	count := 0
+	for _, char := range query {
+		if char == '?' {
+			count++
+			fmt.Fprintf(&rebound, "$%d", count)
+			continue
+		}
+		rebound.WriteRune(char)
+	}
+	return rebound.String()
+}
+
+func (store *sqlSubscriptionStore) Subscribe(sub Subscription) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	// Delete and insert instead of an upsert, which is not portable between databases
+	_, err = tx.Exec(store.rebind("DELETE FROM subscriptions WHERE alert_group = ? AND backend = ? AND message_path = ?"),
+		sub.AlertGroup, sub.Backend, sub.MessagePath)
+	if err == nil {
+		_, err = tx.Exec(store.rebind("INSERT INTO subscriptions (alert_group, backend, message_path, username, friendly_name, email) VALUES (?, ?, ?, ?, ?, ?)"),
+			sub.AlertGroup, sub.Backend, sub.MessagePath, sub.Username, sub.FriendlyName, sub.Email)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (store *sqlSubscriptionStore) Unsubscribe(sub Subscription) error {
+	_, err := store.db.Exec(store.rebind("DELETE FROM subscriptions WHERE alert_group = ? AND backend = ? AND message_path = ?"),
+		sub.AlertGroup, sub.Backend, sub.MessagePath)
+	return err
+}
+
+func (store *sqlSubscriptionStore) query(where string, args ...interface{}) ([]Subscription, error) {
+	rows, err := store.db.Query(store.rebind("SELECT alert_group, backend, message_path, username, friendly_name, email FROM subscriptions "+where+
+		" ORDER BY alert_group, backend, message_path"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var subs []Subscription
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.AlertGroup, &sub.Backend, &sub.MessagePath, &sub.Username, &sub.FriendlyName, &sub.Email); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (store *sqlSubscriptionStore) Subscribers(alertGroup string) ([]Subscription, error) {
+	return store.query("WHERE alert_group = ?", alertGroup)
+}
+
+func (store *sqlSubscriptionStore) All() ([]Subscription, error) {
+	return store.query("")
+}
+
+func (store *sqlSubscriptionStore) Close() error {
+	return store.db.Close()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func testSubscriptionStore(t *testing.T, store SubscriptionStore) {
+	jane := Subscription{AlertGroup: "wakeup", Backend: "hangouts", Userinfo: Userinfo{MessagePath: "spaces/jane", Username: "users/1", FriendlyName: "Jane", Email: "jane@example.com"}}
+	joe := Subscription{AlertGroup: "wakeup", Backend: "hangouts", Userinfo: Userinfo{MessagePath: "spaces/joe", Username: "users/2", FriendlyName: "Joe"}}
+
+	// Concurrent updates must not get lost
+	var wg sync.WaitGroup
+	for _, sub := range []Subscription{jane, joe, {AlertGroup: "all", Backend: "hangouts", Userinfo: jane.Userinfo}} {
+		wg.Add(1)
+		go func(sub Subscription) {
+			defer wg.Done()
+			if err := store.Subscribe(sub); err != nil {
+				t.Error(err)
+			}
+		}(sub)
+	}
+	wg.Wait()
+	// Subscribing twice does not duplicate the subscription
+	if err := store.Subscribe(jane); err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err := store.Subscribers("wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(subs), 2, "")
+	assertEqual(t, subs[0].FriendlyName, "Jane", "")
+	assertEqual(t, subs[0].Email, "jane@example.com", "the email is kept for roles and the audit log")
+	all, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(all), 3, "")
+
+	if err := store.Unsubscribe(jane); err != nil {
+		t.Fatal(err)
+	}
+	subs, err = store.Subscribers("wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(subs), 1, "")
+	assertEqual(t, subs[0].FriendlyName, "Joe", "")
+}
+
+func Test_fileSubscriptionStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "botanist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "subscriptions.json")
+
+	store, err := newSubscriptionStore(SubscriptionStoreConfig{Type: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSubscriptionStore(t, store)
+
+	// A new store reads the state file back
+	store, err = newSubscriptionStore(SubscriptionStoreConfig{Type: "file", Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := store.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(all), 2, "")
+}
+
+func Test_boltSubscriptionStore(t *testing.T) {
+	defer openTestStateDB(t)()
+	store, err := newSubscriptionStore(SubscriptionStoreConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testSubscriptionStore(t, store)
+}
+
+// Test_sqlSubscriptionStore needs a PostgreSQL database, e.g.
+// BOTANIST_TEST_POSTGRES="postgres://botanist@localhost/botanist_test?sslmode=disable"
+func Test_sqlSubscriptionStore(t *testing.T) {
+	dataSource := os.Getenv("BOTANIST_TEST_POSTGRES")
+	if dataSource == "" {
+		t.Skip("BOTANIST_TEST_POSTGRES is not set")
+	}
+	store, err := newSubscriptionStore(SubscriptionStoreConfig{Type: "sql", DataSource: dataSource})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.(*sqlSubscriptionStore).db.Exec("DELETE FROM subscriptions"); err != nil {
+		t.Fatal(err)
+	}
+	testSubscriptionStore(t, store)
+}
+
+func Test_migrateConfigSubscriptions(t *testing.T) {
+	defer openTestStateDB(t)()
+	store := newFileSubscriptionStore("")
+	oldSubscribers := map[string]map[string]HangoutsUser{
+		"wakeup": {"spaces/jane": HangoutsUser{&Userinfo{MessagePath: "spaces/jane", Username: "users/1", FriendlyName: "Jane"}}},
+	}
+	if err := migrateConfigSubscriptions(store, oldSubscribers); err != nil {
+		t.Fatal(err)
+	}
+	subs, err := store.Subscribers("wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(subs), 1, "")
+
+	// Once migrated the config file is ignored, even after everybody unsubscribed
+	if err := store.Unsubscribe(subs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateConfigSubscriptions(store, oldSubscribers); err != nil {
+		t.Fatal(err)
+	}
+	subs, err = store.Subscribers("wakeup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(subs), 0, "")
+}
@@ -7,16 +7,20 @@ type User interface {
 	addToAlertGroup(group string) error
 	delFromAlertGroup(group string) error
 	getUserinfo() *Userinfo
+	// name of the messaging platform the user is on
+	getBackend() string
 }
 
 // Userinfo that can be re-used by User implementations
 type Userinfo struct {
 	// Path used inside of the messaging protocols to reach this user
-	MessagePath string
+	MessagePath string `json:"messagePath"`
 	// username for internal usage
-	Username string
+	Username string `json:"username"`
 	// username used to speak to the user
-	FriendlyName string
+	FriendlyName string `json:"friendlyName"`
+	// email address of the user, if the messaging platform tells us
+	Email string `json:"email,omitempty"`
 }
 
 // HangoutsUser implements User for Hangouts Chat
@@ -31,8 +35,10 @@ type genericMessage struct {
 	MessagePath string
 	// Thread in the chat
 	Thread  string
-	Sender  User
+	Sender  User `json:"-"`
 	Buttons []*genericButton
+	// Alert group an alert card is about, see escalationKey
+	AlertKey string
 }
 
 type genericButton struct {
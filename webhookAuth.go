@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const defaultSignatureHeader = "X-Botanist-Signature"
+
+// maxRequestBody is the largest webhook or admin API request body botanist reads
+const maxRequestBody = 4 << 20
+
+var errRequestTooLarge = errors.New("request body too large")
+
+// WebhookSender is a client allowed to post alerts to botanist.
+// A request is accepted if it satisfies any of the configured credentials of any sender
+type WebhookSender struct {
+	// Name of the sender, used for logging
+	Name string `yaml:"name"`
+
+	BearerToken     string     `yaml:"bearerToken,omitempty"`
+	BearerTokenFile string     `yaml:"bearerTokenFile,omitempty"`
+	BasicAuth       *BasicAuth `yaml:"basicAuth,omitempty"`
+	// Shared secret for HMAC-SHA256 signatures of the request body
+	HMACSecret     string `yaml:"hmacSecret,omitempty"`
+	HMACSecretFile string `yaml:"hmacSecretFile,omitempty"`
+	// Header carrying the hex encoded signature, optionally prefixed with "sha256="
+	SignatureHeader string `yaml:"signatureHeader,omitempty"`
+}
+
+// readSecret returns the secret from file if set, otherwise the inline value
+func readSecret(value, file string) (string, error) {
+	if file == "" {
+		return value, nil
+	}
+	secret, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
+// checkSecrets makes sure all secret files of the sender can be read
+func (sender WebhookSender) checkSecrets() error {
+	if _, err := readSecret(sender.BearerToken, sender.BearerTokenFile); err != nil {
+		return err
+	}
+	if _, err := readSecret(sender.HMACSecret, sender.HMACSecretFile); err != nil {
+		return err
+	}
+	if sender.BasicAuth != nil {
+		if _, err := readSecret(sender.BasicAuth.Password, sender.BasicAuth.PasswordFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// authenticates returns true if the request carries valid credentials of this sender
+func (sender WebhookSender) authenticates(r *http.Request, body []byte) bool {
+	reqLog := log.WithField("sender", sender.Name)
+
+	token, err := readSecret(sender.BearerToken, sender.BearerTokenFile)
+	if err != nil {
+		reqLog.WithError(err).Error("Failed to read bearer token")
+	} else if token != "" && secureCompare(r.Header.Get("Authorization"), "Bearer "+token) {
+		return true
+	}
+
+	if sender.BasicAuth != nil {
+		password, err := readSecret(sender.BasicAuth.Password, sender.BasicAuth.PasswordFile)
+		if err != nil {
+			reqLog.WithError(err).Error("Failed to read basic auth password")
+		} else if username, reqPassword, ok := r.BasicAuth(); ok && password != "" &&
+			secureCompare(username, sender.BasicAuth.Username) && secureCompare(reqPassword, password) {
+			return true
+		}
+	}
+
+	secret, err := readSecret(sender.HMACSecret, sender.HMACSecretFile)
+	if err != nil {
+		reqLog.WithError(err).Error("Failed to read HMAC secret")
+	} else if secret != "" {
+		header := sender.SignatureHeader
+		if header == "" {
+			header = defaultSignatureHeader
+		}
+		signature := strings.TrimPrefix(r.Header.Get(header), "sha256=")
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		if signature != "" && secureCompare(strings.ToLower(signature), hex.EncodeToString(mac.Sum(nil))) {
+			return true
+		}
+	}
+	return false
+}
+
+// peekBody reads the request body for checking signatures and leaves it to be read again by the handler.
+// Bodies larger than maxRequestBody are refused with errRequestTooLarge
+func peekBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	r.Body.Close()
+	if err != nil && len(body) >= maxRequestBody {
+		return nil, errRequestTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// authenticateWebhook only passes on requests of configured webhook senders.
+// Without any configured senders every request is rejected unless allowUnauthenticated is set
+func authenticateWebhook(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reqLog := log.WithField("remote_addr", r.RemoteAddr).WithField("path", r.URL.Path)
+		senders := currentConfig().WebhookSenders
+		if len(senders) == 0 {
+			if currentConfig().AllowUnauthenticated {
+				r.Body = http.MaxBytesReader(w, r.Body, maxRequestBody)
+				next(w, r)
+				return
+			}
+			reqLog.Warnln("Rejecting webhook, no webhookSenders are configured")
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := peekBody(w, r)
+		if err == errRequestTooLarge {
+			reqLog.Warnln("Rejecting webhook larger than the limit")
+			http.Error(w, "", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			reqLog.WithError(err).Error("Failed to read request body")
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		for _, sender := range senders {
+			if sender.authenticates(r, body) {
+				reqLog.Debugf("Request authenticated as %s", sender.Name)
+				next(w, r)
+				return
+			}
+		}
+		reqLog.Warnln("Rejecting unauthenticated webhook")
+		http.Error(w, "", http.StatusUnauthorized)
+	}
+}
+
+// sign adds the credentials of this sender to a request, the counterpart of authenticates
+func (sender WebhookSender) sign(r *http.Request, body []byte) error {
+	token, err := readSecret(sender.BearerToken, sender.BearerTokenFile)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	} else if sender.BasicAuth != nil {
+		password, err := readSecret(sender.BasicAuth.Password, sender.BasicAuth.PasswordFile)
+		if err != nil {
+			return err
+		}
+		r.SetBasicAuth(sender.BasicAuth.Username, password)
+	}
+
+	secret, err := readSecret(sender.HMACSecret, sender.HMACSecretFile)
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		header := sender.SignatureHeader
+		if header == "" {
+			header = defaultSignatureHeader
+		}
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		r.Header.Set(header, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	return nil
+}
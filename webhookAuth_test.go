@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_authenticateWebhook(t *testing.T) {
+	oldSenders := botanistConfig.WebhookSenders
+	defer func() { botanistConfig.WebhookSenders = oldSenders }()
+	botanistConfig.WebhookSenders = []WebhookSender{
+		{Name: "token", BearerToken: "secret-token"},
+		{Name: "basic", BasicAuth: &BasicAuth{Username: "am", Password: "secret-password"}},
+		{Name: "hmac", HMACSecret: "secret-key"},
+	}
+	body := `{"abc": ""}`
+	mac := hmac.New(sha256.New, []byte("secret-key"))
+	mac.Write([]byte(body))
+	signature := hex.EncodeToString(mac.Sum(nil))
+
+	for name, test := range map[string]struct {
+		prepare func(r *http.Request)
+		code    int
+	}{
+		"no credentials": {func(r *http.Request) {}, http.StatusUnauthorized},
+		"bearer token":   {func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret-token") }, http.StatusOK},
+		"wrong token":    {func(r *http.Request) { r.Header.Set("Authorization", "Bearer wrong") }, http.StatusUnauthorized},
+		"basic auth":     {func(r *http.Request) { r.SetBasicAuth("am", "secret-password") }, http.StatusOK},
+		"wrong password": {func(r *http.Request) { r.SetBasicAuth("am", "wrong") }, http.StatusUnauthorized},
+		"hmac":           {func(r *http.Request) { r.Header.Set(defaultSignatureHeader, "sha256="+signature) }, http.StatusOK},
+		"wrong hmac":     {func(r *http.Request) { r.Header.Set(defaultSignatureHeader, "sha256=00") }, http.StatusUnauthorized},
+	} {
+		req, err := http.NewRequest("POST", "/alert", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		test.prepare(req)
+		rr := httptest.NewRecorder()
+		authenticateWebhook(promAlertHandler).ServeHTTP(rr, req)
+		assertEqual(t, rr.Code, test.code, name)
+	}
+}
+
+func Test_authenticateWebhookWithoutSenders(t *testing.T) {
+	oldConfig := botanistConfig
+	defer func() { botanistConfig = oldConfig }()
+	botanistConfig = &config{}
+
+	for allow, code := range map[bool]int{false: http.StatusUnauthorized, true: http.StatusOK} {
+		botanistConfig.AllowUnauthenticated = allow
+		req, err := http.NewRequest("POST", "/alert", strings.NewReader(`{"abc": ""}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		authenticateWebhook(promAlertHandler).ServeHTTP(rr, req)
+		assertEqual(t, rr.Code, code, fmt.Sprintf("allowUnauthenticated: %t", allow))
+	}
+}
+
+func Test_authenticateWebhookBodyLimit(t *testing.T) {
+	oldSenders := botanistConfig.WebhookSenders
+	defer func() { botanistConfig.WebhookSenders = oldSenders }()
+	botanistConfig.WebhookSenders = []WebhookSender{{Name: "token", BearerToken: "secret-token"}}
+
+	req, err := http.NewRequest("POST", "/alert", strings.NewReader(strings.Repeat(" ", maxRequestBody+1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret-token")
+	rr := httptest.NewRecorder()
+	authenticateWebhook(promAlertHandler).ServeHTTP(rr, req)
+	assertEqual(t, rr.Code, http.StatusRequestEntityTooLarge, "")
+}